@@ -0,0 +1,59 @@
+package main
+
+import (
+	"io"
+	"maps"
+	"sync"
+)
+
+// Aggregator keeps running totals across any number of inputs, so batches
+// can be fed over time and the current aggregates queried in between. It is
+// safe for concurrent use.
+type Aggregator struct {
+	opts Options
+
+	// mu guards totals; a batch is merged under the write lock, so readers
+	// see all of its stations or none of them
+	mu     sync.RWMutex
+	totals map[string]Stats
+}
+
+func NewAggregator(opts Options) *Aggregator {
+	return &Aggregator{
+		opts:   opts,
+		totals: make(map[string]Stats),
+	}
+}
+
+// Add aggregates everything read from r into the running totals. On error
+// the totals are left untouched.
+func (a *Aggregator) Add(r io.Reader) error {
+	stats, err := AggregateReader(r, a.opts)
+	if err != nil {
+		return err
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	MergeStats(a.totals, stats)
+	return nil
+}
+
+// Reset drops all totals, so the Aggregator can start over with an
+// unrelated dataset. The storage of the totals is kept for reuse.
+func (a *Aggregator) Reset() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	clear(a.totals)
+}
+
+// Snapshot returns a copy of the current totals. Snapshots taken while Add
+// is running hold every batch merged so far and nothing of the others.
+func (a *Aggregator) Snapshot() map[string]Stats {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	return maps.Clone(a.totals)
+}
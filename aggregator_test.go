@@ -0,0 +1,114 @@
+package main
+
+import (
+	"maps"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestAggregatorAdd(t *testing.T) {
+	lines := strings.SplitAfter(fixture, "\n")
+	first, second := strings.Join(lines[:5], ""), strings.Join(lines[5:], "")
+
+	a := NewAggregator(Options{})
+	if err := a.Add(strings.NewReader(first)); err != nil {
+		t.Fatal(err)
+	}
+
+	snapshot := a.Snapshot()
+	if got := snapshot["Hamburg"]; got != (Stats{count: 2, min: 120, max: 342, sum: 462}) {
+		t.Errorf("Hamburg after first batch = %+v", got)
+	}
+
+	if err := a.Add(strings.NewReader(second)); err != nil {
+		t.Fatal(err)
+	}
+
+	want, err := AggregateReader(strings.NewReader(fixture), Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := a.Snapshot(); !maps.Equal(got, want) {
+		t.Errorf("got  %v\nwant %v", got, want)
+	}
+
+	// earlier snapshots are copies
+	if got := snapshot["Hamburg"]; got.count != 2 {
+		t.Errorf("first snapshot changed to %+v", got)
+	}
+}
+
+func TestAggregatorReset(t *testing.T) {
+	jobs := []string{fixture, "Hamburg;1.0\nLima;-2.5\nHamburg;3.0\n"}
+
+	reused := NewAggregator(Options{})
+	for i, job := range jobs {
+		if i != 0 {
+			reused.Reset()
+		}
+		if err := reused.Add(strings.NewReader(job)); err != nil {
+			t.Fatal(err)
+		}
+
+		fresh := NewAggregator(Options{})
+		if err := fresh.Add(strings.NewReader(job)); err != nil {
+			t.Fatal(err)
+		}
+		if got, want := reused.Snapshot(), fresh.Snapshot(); !maps.Equal(got, want) {
+			t.Errorf("job %d after Reset: got  %v\nwant %v", i, got, want)
+		}
+	}
+
+	reused.Reset()
+	if got := reused.Snapshot(); len(got) != 0 {
+		t.Errorf("snapshot after Reset = %v", got)
+	}
+}
+
+// TestAggregatorConcurrentSnapshots is meant for go test -race
+func TestAggregatorConcurrentSnapshots(t *testing.T) {
+	const adders, batches = 4, 50
+	a := NewAggregator(Options{Workers: 1, ChunkSize: 1024})
+
+	var wg sync.WaitGroup
+	for i := 0; i < adders; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < batches; j++ {
+				if err := a.Add(strings.NewReader("Hamburg;1.0\nLima;-2.5\n")); err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}()
+	}
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+
+	var last int64
+	for finished := false; !finished; {
+		select {
+		case <-done:
+			finished = true
+		default:
+		}
+
+		snapshot := a.Snapshot()
+		hamburg, lima := snapshot["Hamburg"].Count(), snapshot["Lima"].Count()
+		if hamburg != lima {
+			t.Fatalf("torn snapshot: Hamburg %d, Lima %d readings", hamburg, lima)
+		}
+		if hamburg < last {
+			t.Fatalf("count went from %d down to %d", last, hamburg)
+		}
+		last = hamburg
+	}
+	if last != adders*batches {
+		t.Errorf("final count %d, want %d", last, adders*batches)
+	}
+}
@@ -0,0 +1,25 @@
+package main
+
+// nameArenaBlockSize is the size of the blocks a nameArena packs names into
+const nameArenaBlockSize = 64 * 1024
+
+// nameArena owns copies of station names. It packs them back to back into
+// a few large blocks instead of allocating every name on its own, which
+// saves the per allocation overhead and keeps the names close together for
+// the output loop. The zero value is ready to use.
+type nameArena struct {
+	block []byte
+}
+
+// clone returns a copy of name that lives in the arena
+func (a *nameArena) clone(name []byte) []byte {
+	if len(name) > cap(a.block)-len(a.block) {
+		// the rest of the current block is wasted, at most a name's length
+		a.block = make([]byte, 0, max(nameArenaBlockSize, len(name)))
+	}
+
+	start := len(a.block)
+	a.block = append(a.block, name...)
+	// cap the copy, so appending to it can't overwrite the next name
+	return a.block[start:len(a.block):len(a.block)]
+}
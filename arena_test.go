@@ -0,0 +1,38 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestNameArena(t *testing.T) {
+	var names [][]byte
+	for i := 0; i < 10_000; i++ {
+		names = append(names, []byte(fmt.Sprintf("station-%d%s", i, strings.Repeat("x", i%100))))
+	}
+	// larger than a block
+	names = append(names, bytes.Repeat([]byte("y"), 2*nameArenaBlockSize), []byte(""), []byte("after"))
+
+	var arena nameArena
+	clones := make([][]byte, len(names))
+	for i, name := range names {
+		clones[i] = arena.clone(name)
+	}
+
+	// appending to a clone must not reach into the next one
+	_ = append(clones[0], "overwrite"...)
+
+	for i, name := range names {
+		if !bytes.Equal(clones[i], name) {
+			t.Fatalf("name %d came back as %.50q, want %.50q", i, clones[i], name)
+		}
+	}
+
+	// the clones are copies
+	names[1][0] = 'S'
+	if clones[1][0] != 's' {
+		t.Error("clone shares memory with the original")
+	}
+}
@@ -1,8 +1,20 @@
 package main
 
 import (
+	"bytes"
 	"fmt"
+	"hash/maphash"
+	"io"
+	"math"
+	"math/rand/v2"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
 	"testing"
+
+	"github.com/zhehlovvalentyn/1brc/custom_map"
 )
 
 func BenchmarkChanSize(b *testing.B) {
@@ -19,7 +31,7 @@ func BenchmarkChanSize(b *testing.B) {
 	for _, testCase := range testCases {
 		b.Run(fmt.Sprintf("chanSize=%d,chunkSize=%d", testCase.chanSize, testCase.chunkSize), func(b *testing.B) {
 			for i := 0; i < b.N; i++ {
-				evaluate(testCase.fileName, testCase.chanSize, testCase.chunkSize, false)
+				evaluate(testCase.fileName, testCase.chanSize, testCase.chunkSize, Options{})
 			}
 		})
 	}
@@ -45,26 +57,493 @@ func BenchmarkChunkSize(b *testing.B) {
 	for _, testCase := range testCases {
 		b.Run(fmt.Sprintf("chanSize=%d,chunkSize=%d", testCase.chanSize, testCase.chunkSize), func(b *testing.B) {
 			for i := 0; i < b.N; i++ {
-				evaluate(testCase.fileName, testCase.chanSize, testCase.chunkSize, false)
+				evaluate(testCase.fileName, testCase.chanSize, testCase.chunkSize, Options{})
 			}
 		})
 	}
 }
 
+// BenchmarkAdaptiveChunkSize runs the streaming engine on files of several
+// sizes with the fixed 16 MiB chunks it used to default to and with the
+// size adaptiveChunkSize picks
+func BenchmarkAdaptiveChunkSize(b *testing.B) {
+	for _, size := range []int{64 << 10, 4 << 20, 64 << 20} {
+		fileName := filepath.Join(b.TempDir(), "measurements.txt")
+		content := make([]byte, 0, size+32)
+		for i := 0; len(content) < size; i++ {
+			content = fmt.Appendf(content, "station-%d;%d.%d\n", i%400, i%100-50, i%10)
+		}
+		if err := os.WriteFile(fileName, content, 0o644); err != nil {
+			b.Fatal(err)
+		}
+
+		for _, chunk := range []struct {
+			name string
+			size int
+		}{
+			{"fixed", defaultChunkSize},
+			{"adaptive", 0},
+		} {
+			b.Run(fmt.Sprintf("file=%dK/%s", size>>10, chunk.name), func(b *testing.B) {
+				b.SetBytes(int64(len(content)))
+				b.ReportAllocs()
+				for i := 0; i < b.N; i++ {
+					if _, err := evaluate(fileName, workerCount, chunk.size, Options{}); err != nil {
+						b.Fatal(err)
+					}
+				}
+			})
+		}
+	}
+}
+
 func BenchmarkEvaluate(b *testing.B) {
 	testCases := []struct {
 		testName string
-		function func(string, int, int, bool) error
+		function func(string, Options) (*aggregation, error)
 		fileName string
 	}{
-		{"read", evaluate, "data/measurements_100m.txt"},
+		{"read", func(fileName string, opts Options) (*aggregation, error) {
+			return evaluate(fileName, workerCount, 16*1024*1024, opts)
+		}, "data/measurements_100m.txt"},
 		{"mmap", evaluateMmap, "data/measurements_100m.txt"},
 	}
 
 	for _, testCase := range testCases {
 		b.Run(testCase.testName, func(b *testing.B) {
 			for i := 0; i < b.N; i++ {
-				testCase.function(testCase.fileName, workerCount, 16*1024*1024, false)
+				testCase.function(testCase.fileName, Options{})
+			}
+		})
+	}
+}
+
+func BenchmarkCPU(b *testing.B) {
+	defer runtime.GOMAXPROCS(runtime.GOMAXPROCS(0))
+
+	for cpu := 1; cpu <= runtime.NumCPU(); cpu *= 2 {
+		runtime.GOMAXPROCS(cpu)
+		opts := Options{Workers: cpu}
+
+		b.Run(fmt.Sprintf("read/cpu=%d", cpu), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				evaluate("data/measurements_100m.txt", workerCount, 16*1024*1024, opts)
+			}
+		})
+		b.Run(fmt.Sprintf("mmap/cpu=%d", cpu), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				evaluateMmap("data/measurements_100m.txt", opts)
+			}
+		})
+	}
+}
+
+func BenchmarkMmapWorkers(b *testing.B) {
+	const fileName = "data/measurements_100m.txt"
+	info, err := os.Stat(fileName)
+	if err != nil {
+		b.Skipf("fixture missing: %v", err)
+	}
+
+	for workers := 1; workers <= 4*runtime.NumCPU(); workers *= 2 {
+		opts := Options{Workers: workers}
+
+		b.Run(fmt.Sprintf("workers=%d", workers), func(b *testing.B) {
+			b.SetBytes(info.Size())
+
+			var rows int64
+			for i := 0; i < b.N; i++ {
+				agg, err := evaluateMmap(fileName, opts)
+				if err != nil {
+					b.Fatal(err)
+				}
+				rows += agg.report.TotalLines
+			}
+			b.ReportMetric(float64(rows)/b.Elapsed().Seconds(), "rows/s")
+		})
+	}
+}
+
+// BenchmarkStationLookup compares the name keyed symbol map with the hash
+// keyed one it replaced, which could merge two stations on a collision
+func BenchmarkStationLookup(b *testing.B) {
+	names := make([][]byte, 413)
+	for i := range names {
+		names[i] = []byte(fmt.Sprintf("station-%d", i))
+	}
+
+	b.Run("hash", func(b *testing.B) {
+		seed := maphash.MakeSeed()
+		symbols := make(map[uint64]uint64, len(names))
+		for i, name := range names {
+			symbols[maphash.Bytes(seed, name)] = uint64(i)
+		}
+
+		var sum uint64
+		for i := 0; i < b.N; i++ {
+			sum += symbols[maphash.Bytes(seed, names[i%len(names)])]
+		}
+		_ = sum
+	})
+	b.Run("name", func(b *testing.B) {
+		opts := Options{}
+		symbols := make(map[string]uint64, len(names))
+		for i, name := range names {
+			symbols[string(name)] = uint64(i)
+		}
+
+		var sum uint64
+		for i := 0; i < b.N; i++ {
+			sum += symbols[string(opts.stationKey(names[i%len(names)]))]
+		}
+		_ = sum
+	})
+}
+
+// BenchmarkSmallFile shows the per-run overhead that doesn't depend on the
+// input size, like sizing the per-worker accumulators
+func BenchmarkSmallFile(b *testing.B) {
+	fileName := filepath.Join(b.TempDir(), "measurements.txt")
+	var content []byte
+	for i := 0; i < 1000; i++ {
+		content = fmt.Appendf(content, "station-%d;%d.%d\n", i%10, i%100-50, i%10)
+	}
+	if err := os.WriteFile(fileName, content, 0o644); err != nil {
+		b.Fatal(err)
+	}
+
+	for _, engine := range []struct {
+		name     string
+		evaluate func(string, Options) (*aggregation, error)
+	}{
+		{"read", func(fileName string, opts Options) (*aggregation, error) {
+			return evaluate(fileName, workerCount, 64*1024, opts)
+		}},
+		{"mmap", evaluateMmap},
+	} {
+		b.Run(engine.name, func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				if _, err := engine.evaluate(fileName, Options{}); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+// BenchmarkNameStorage compares owning station names with a copy each to
+// packing them into a nameArena
+func BenchmarkNameStorage(b *testing.B) {
+	names := make([][]byte, numberOfMaxStations)
+	for i := range names {
+		names[i] = []byte(fmt.Sprintf("station-%d", i))
+	}
+
+	for _, storage := range []struct {
+		name  string
+		clone func() func([]byte) []byte
+	}{
+		{"clone", func() func([]byte) []byte { return bytes.Clone }},
+		{"arena", func() func([]byte) []byte { return new(nameArena).clone }},
+	} {
+		b.Run(storage.name, func(b *testing.B) {
+			var before, after runtime.MemStats
+			runtime.ReadMemStats(&before)
+			for i := 0; i < b.N; i++ {
+				clone := storage.clone()
+				owned := make([][]byte, len(names))
+				for j, name := range names {
+					owned[j] = clone(name)
+				}
+			}
+			runtime.ReadMemStats(&after)
+
+			b.ReportMetric(float64(after.TotalAlloc-before.TotalAlloc)/float64(b.N*len(names)), "B/name")
+			b.ReportMetric(float64(after.Mallocs-before.Mallocs)/float64(b.N*len(names)), "allocs/name")
+		})
+	}
+}
+
+// BenchmarkMmapParts runs the mmap engine on a file whose first half has
+// long names and the second half short ones, so equal byte ranges hold
+// very different row counts. More parts than workers let the workers with
+// the long lines finish early and help with the rest.
+func BenchmarkMmapParts(b *testing.B) {
+	fileName := filepath.Join(b.TempDir(), "measurements.txt")
+	var content []byte
+	for i := 0; i < 200_000; i++ {
+		content = fmt.Appendf(content, "%s-%d;%d.%d\n", strings.Repeat("long", 20), i%50, i%100-50, i%10)
+	}
+	for i := 0; i < 2_000_000; i++ {
+		content = fmt.Appendf(content, "s%d;%d.%d\n", i%50, i%100-50, i%10)
+	}
+	if err := os.WriteFile(fileName, content, 0o644); err != nil {
+		b.Fatal(err)
+	}
+
+	workers := runtime.NumCPU()
+	for _, parts := range []int{0, 4 * workers, 16 * workers} {
+		opts := Options{Workers: workers, Parts: parts}
+
+		b.Run(fmt.Sprintf("parts=%d", max(parts, workers)), func(b *testing.B) {
+			b.SetBytes(int64(len(content)))
+
+			var rows int64
+			for i := 0; i < b.N; i++ {
+				agg, err := evaluateMmap(fileName, opts)
+				if err != nil {
+					b.Fatal(err)
+				}
+				rows += agg.report.TotalLines
+			}
+			b.ReportMetric(float64(rows)/b.Elapsed().Seconds(), "rows/s")
+		})
+	}
+}
+
+// BenchmarkMapComparison measures RobinHoodMap against the built-in map on
+// 10,000 station names. insert builds a whole map per op, lookup and mixed
+// handle one reading per op, mixed being the 1BRC pattern of a lookup, an
+// insert for a new station and an update. The bytes variants key by the
+// []byte the parser produces; RobinHoodMap only takes strings, so they pay
+// for the conversion.
+func BenchmarkMapComparison(b *testing.B) {
+	const stations = 10_000
+	names := make([]string, stations)
+	nameBytes := make([][]byte, stations)
+	for i := range names {
+		names[i] = fmt.Sprintf("station-%d", i)
+		nameBytes[i] = []byte(names[i])
+	}
+
+	// a fixed, file like order of readings
+	rng := rand.New(rand.NewPCG(1, 2))
+	readings := make([]int, 1<<16)
+	for i := range readings {
+		readings[i] = rng.IntN(stations)
+	}
+	newInfo := func() interface{} { return &cityTemperatureInfo{} }
+
+	b.Run("insert/robinhood", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			rhm := custom_map.NewRobinHoodMap(16)
+			for _, name := range names {
+				rhm.Put(name, &cityTemperatureInfo{})
+			}
+		}
+	})
+	b.Run("insert/builtin", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			m := make(map[string]*cityTemperatureInfo)
+			for _, name := range names {
+				m[name] = &cityTemperatureInfo{}
+			}
+		}
+	})
+
+	rhm := custom_map.NewRobinHoodMap(16)
+	m := make(map[string]*cityTemperatureInfo)
+	for _, name := range names {
+		rhm.Put(name, &cityTemperatureInfo{})
+		m[name] = &cityTemperatureInfo{}
+	}
+	b.Run("lookup/robinhood", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			if _, ok := rhm.Get(names[readings[i%len(readings)]]); !ok {
+				b.Fatal("station missing")
+			}
+		}
+	})
+	b.Run("lookup/builtin", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			if _, ok := m[names[readings[i%len(readings)]]]; !ok {
+				b.Fatal("station missing")
+			}
+		}
+	})
+
+	b.Run("mixed/robinhood/string", func(b *testing.B) {
+		b.ReportAllocs()
+		rhm := custom_map.NewRobinHoodMap(16)
+		for i := 0; i < b.N; i++ {
+			info, _ := rhm.GetOrInsert(names[readings[i%len(readings)]], newInfo)
+			info.(*cityTemperatureInfo).add(int64(i%1999 - 999))
+		}
+	})
+	b.Run("mixed/robinhood/bytes", func(b *testing.B) {
+		b.ReportAllocs()
+		rhm := custom_map.NewRobinHoodMap(16)
+		for i := 0; i < b.N; i++ {
+			info, _ := rhm.GetOrInsert(string(nameBytes[readings[i%len(readings)]]), newInfo)
+			info.(*cityTemperatureInfo).add(int64(i%1999 - 999))
+		}
+	})
+	b.Run("mixed/builtin/string", func(b *testing.B) {
+		b.ReportAllocs()
+		m := make(map[string]*cityTemperatureInfo)
+		for i := 0; i < b.N; i++ {
+			name := names[readings[i%len(readings)]]
+			info, ok := m[name]
+			if !ok {
+				info = &cityTemperatureInfo{}
+				m[name] = info
+			}
+			info.add(int64(i%1999 - 999))
+		}
+	})
+	b.Run("mixed/builtin/bytes", func(b *testing.B) {
+		b.ReportAllocs()
+		m := make(map[string]*cityTemperatureInfo)
+		for i := 0; i < b.N; i++ {
+			name := nameBytes[readings[i%len(readings)]]
+			info, ok := m[string(name)]
+			if !ok {
+				info = &cityTemperatureInfo{}
+				m[string(name)] = info
+			}
+			info.add(int64(i%1999 - 999))
+		}
+	})
+}
+
+// BenchmarkMergeWorkerResults compares the merge of the populated indices
+// with the scan of every index it replaced, on workers that each saw a few
+// of many discovered stations
+func BenchmarkMergeWorkerResults(b *testing.B) {
+	const discovered, seen = 10000, 10
+
+	names := make([][]byte, discovered)
+	symbols := make(map[string]uint64, discovered)
+	for i := range names {
+		names[i] = fmt.Appendf(nil, "station-%d", i)
+		symbols[string(names[i])] = uint64(i)
+	}
+	results := make(WorkerResults, workerCount)
+	for w := range results {
+		results[w].cities = make(cityMap, discovered)
+		for i := 0; i < seen; i++ {
+			results[w].add(uint64(w*seen+i), int64(i), false)
+		}
+	}
+
+	b.Run("populated", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			mergeWorkerResults(results, names, symbols)
+		}
+	})
+	b.Run("every-index", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			merged := make(cityMap, discovered)
+			for w := range results {
+				for j, info := range results[w].cities {
+					merged[j].merge(info)
+				}
+			}
+		}
+	})
+}
+
+// BenchmarkParseTemperature compares the decimal parser with the one for
+// whole degrees behind -no-decimal, on the same values
+func BenchmarkParseTemperature(b *testing.B) {
+	decimals := make([][]byte, 199)
+	integers := make([][]byte, 199)
+	for i := range decimals {
+		decimals[i] = fmt.Appendf(nil, "%d.0", i-99)
+		integers[i] = fmt.Appendf(nil, "%d", i-99)
+	}
+
+	for _, parser := range []struct {
+		name   string
+		inputs [][]byte
+		opts   Options
+	}{
+		{"decimal", decimals, Options{}},
+		{"no-decimal", integers, Options{NoDecimal: true}},
+	} {
+		b.Run(parser.name, func(b *testing.B) {
+			var sum int64
+			for i := 0; i < b.N; i++ {
+				t, _ := parser.opts.parseTemperature(parser.inputs[i%len(parser.inputs)])
+				sum += t
+			}
+			_ = sum
+		})
+	}
+}
+
+// benchmarkAggregation builds the aggregation of n stations with a few
+// readings each, as the engines leave it for the output
+func benchmarkAggregation(n int) *aggregation {
+	agg := &aggregation{stationSymbolMap: make(map[string]uint64, n)}
+	rng := rand.New(rand.NewPCG(1, 2))
+	for i := 0; i < n; i++ {
+		name := fmt.Appendf(nil, "station-%05d", i)
+		agg.stationNames = append(agg.stationNames, name)
+		agg.stationSymbolMap[string(name)] = uint64(i)
+
+		var result cityTemperatureInfo
+		for range 3 {
+			result.add(rng.Int64N(1999) - 999)
+		}
+		agg.results = append(agg.results, result)
+	}
+	return agg
+}
+
+// BenchmarkFormatOutput measures the output phase alone, on the results of
+// 10,000 stations: looking up every station, formatting and writing. The
+// temperature variants format min, mean and max of a station the way the
+// output does, with its fixed point formatter, with strconv.FormatFloat, and
+// with the float based rounding the output takes for other precisions.
+func BenchmarkFormatOutput(b *testing.B) {
+	agg := benchmarkAggregation(numberOfMaxStations)
+
+	for _, format := range []string{formatText, formatLines, formatJSON, formatCSV} {
+		b.Run(format, func(b *testing.B) {
+			opts := Options{Format: format}
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				if err := writeResults(io.Discard, agg, &opts); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+
+	formatters := []struct {
+		name   string
+		append func(buf []byte, result cityTemperatureInfo) []byte
+	}{
+		{"output", func(buf []byte, result cityTemperatureInfo) []byte {
+			var opts Options
+			buf = appendTemperature(buf, result.min, &opts)
+			buf = appendMean(buf, result, &opts)
+			return appendTemperature(buf, result.max, &opts)
+		}},
+		{"FormatFloat", func(buf []byte, result cityTemperatureInfo) []byte {
+			buf = append(buf, strconv.FormatFloat(float64(result.min)/10, 'f', 1, 64)...)
+			buf = append(buf, strconv.FormatFloat(math.Round(float64(result.sum)/float64(result.count))/10, 'f', 1, 64)...)
+			return append(buf, strconv.FormatFloat(float64(result.max)/10, 'f', 1, 64)...)
+		}},
+		{"float", func(buf []byte, result cityTemperatureInfo) []byte {
+			buf = appendRounded(buf, float64(result.min), 1, 1)
+			buf = appendRounded(buf, float64(result.sum), float64(result.count), 1)
+			return appendRounded(buf, float64(result.max), 1, 1)
+		}},
+	}
+	for _, formatter := range formatters {
+		b.Run("temperature/"+formatter.name, func(b *testing.B) {
+			buf := make([]byte, 0, 64)
+			for i := 0; i < b.N; i++ {
+				buf = formatter.append(buf[:0], agg.results[i%len(agg.results)])
 			}
 		})
 	}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// The binary format stores exact totals so partial results can be merged
+// without the rounding of the text output. All integers are little-endian:
+//
+//	header:  magic "1BRB" | version uint32 | station count uint32
+//	station: name length uint16 | name | count int64 | min int64 | max int64 | sum int64
+//
+// min, max and sum are in tenths of a degree, like Stats.
+const (
+	binaryMagic   = "1BRB"
+	binaryVersion = 1
+)
+
+// writeBinary writes entries in the binary format
+func writeBinary(w io.Writer, entries []stationResult) error {
+	bw := bufio.NewWriterSize(w, 64*1024)
+
+	buf := make([]byte, 0, 512)
+	buf = append(buf, binaryMagic...)
+	buf = binary.LittleEndian.AppendUint32(buf, binaryVersion)
+	buf = binary.LittleEndian.AppendUint32(buf, uint32(len(entries)))
+	if _, err := bw.Write(buf); err != nil {
+		return err
+	}
+
+	for _, entry := range entries {
+		if len(entry.name) > 0xFFFF {
+			return fmt.Errorf("station name of %d bytes is too long for the binary format", len(entry.name))
+		}
+
+		if entry.result.sumHigh != 0 {
+			return fmt.Errorf("sum of station %q exceeds 64 bits, which the binary format can't hold", entry.name)
+		}
+
+		buf = buf[:0]
+		buf = binary.LittleEndian.AppendUint16(buf, uint16(len(entry.name)))
+		buf = append(buf, entry.name...)
+		buf = binary.LittleEndian.AppendUint64(buf, uint64(entry.result.count))
+		buf = binary.LittleEndian.AppendUint64(buf, uint64(entry.result.min))
+		buf = binary.LittleEndian.AppendUint64(buf, uint64(entry.result.max))
+		buf = binary.LittleEndian.AppendUint64(buf, uint64(entry.result.sum))
+		if _, err := bw.Write(buf); err != nil {
+			return err
+		}
+	}
+
+	return bw.Flush()
+}
+
+// ReadBinary loads results written with -format binary
+func ReadBinary(r io.Reader) (map[string]Stats, error) {
+	br := bufio.NewReader(r)
+
+	var header [12]byte
+	if _, err := io.ReadFull(br, header[:]); err != nil {
+		return nil, fmt.Errorf("reading binary header: %w", err)
+	}
+	if string(header[:4]) != binaryMagic {
+		return nil, errors.New("not a binary results file")
+	}
+	if version := binary.LittleEndian.Uint32(header[4:8]); version != binaryVersion {
+		return nil, fmt.Errorf("unsupported binary results version %d", version)
+	}
+	count := binary.LittleEndian.Uint32(header[8:12])
+
+	stats := make(map[string]Stats, count)
+	var fields [32]byte
+	for i := uint32(0); i < count; i++ {
+		var length [2]byte
+		if _, err := io.ReadFull(br, length[:]); err != nil {
+			return nil, fmt.Errorf("reading station %d: %w", i, err)
+		}
+		name := make([]byte, binary.LittleEndian.Uint16(length[:]))
+		if _, err := io.ReadFull(br, name); err != nil {
+			return nil, fmt.Errorf("reading station %d: %w", i, err)
+		}
+		if _, err := io.ReadFull(br, fields[:]); err != nil {
+			return nil, fmt.Errorf("reading station %q: %w", name, err)
+		}
+
+		stats[string(name)] = Stats{
+			count: int64(binary.LittleEndian.Uint64(fields[0:])),
+			min:   int64(binary.LittleEndian.Uint64(fields[8:])),
+			max:   int64(binary.LittleEndian.Uint64(fields[16:])),
+			sum:   int64(binary.LittleEndian.Uint64(fields[24:])),
+		}
+	}
+
+	return stats, nil
+}
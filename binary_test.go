@@ -0,0 +1,57 @@
+package main
+
+import (
+	"bytes"
+	"maps"
+	"strings"
+	"testing"
+)
+
+func TestBinaryRoundTrip(t *testing.T) {
+	lines := strings.SplitAfter(fixture, "\n")
+	parts := []string{strings.Join(lines[:7], ""), strings.Join(lines[7:], "")}
+
+	merged := map[string]Stats{}
+	for _, part := range parts {
+		opts := Options{Format: formatBinary}
+		agg, err := evaluateReader(strings.NewReader(part), 1, 1024, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var encoded bytes.Buffer
+		if err := writeResults(&encoded, agg, &opts); err != nil {
+			t.Fatal(err)
+		}
+
+		loaded, err := ReadBinary(&encoded)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := agg.stats(&opts); !maps.Equal(loaded, want) {
+			t.Errorf("loaded %v\nwant   %v", loaded, want)
+		}
+
+		for name, s := range loaded {
+			total := merged[name]
+			total.merge(s)
+			merged[name] = total
+		}
+	}
+
+	want, err := AggregateReader(strings.NewReader(fixture), Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !maps.Equal(merged, want) {
+		t.Errorf("merged %v\nwant   %v", merged, want)
+	}
+}
+
+func TestReadBinaryRejectsGarbage(t *testing.T) {
+	for _, input := range []string{"", "1BRB", "JSON{}......", "1BRB\x02\x00\x00\x00\x00\x00\x00\x00", "1BRB\x01\x00\x00\x00\x01\x00\x00\x00\x03\x00ab"} {
+		if _, err := ReadBinary(strings.NewReader(input)); err == nil {
+			t.Errorf("ReadBinary(%q) succeeded", input)
+		}
+	}
+}
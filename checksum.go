@@ -0,0 +1,59 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+	"os"
+)
+
+// Algorithms Options.Checksum can hash the input with
+const (
+	checksumCRC32  = "crc32"
+	checksumSHA256 = "sha256"
+)
+
+// newChecksum returns a hash of the named algorithm. Unknown names fall back
+// to sha256, validateChecksum rejects them up front.
+func newChecksum(algo string) hash.Hash {
+	if algo == checksumCRC32 {
+		return crc32.NewIEEE()
+	}
+	return sha256.New()
+}
+
+// validateChecksum checks the algorithm passed with -checksum
+func validateChecksum(algo string) error {
+	switch algo {
+	case "", checksumCRC32, checksumSHA256:
+		return nil
+	}
+	return fmt.Errorf("unknown -checksum %q, want crc32 or sha256", algo)
+}
+
+// fileChecksum hashes fileName in a pass of its own, for the engines that
+// don't read the input in order
+func fileChecksum(fileName string, algo string) ([]byte, error) {
+	f, err := os.Open(fileName)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	h := newChecksum(algo)
+	if _, err := io.Copy(h, f); err != nil {
+		return nil, err
+	}
+	return h.Sum(nil), nil
+}
+
+// appendChecksum appends the checksum line, like sha256 9f86d0...
+func appendChecksum(buf []byte, algo string, sum []byte) []byte {
+	buf = append(buf, algo...)
+	buf = append(buf, ' ')
+	buf = hex.AppendEncode(buf, sum)
+	return append(buf, '\n')
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"hash/crc32"
+	"strings"
+	"testing"
+)
+
+func TestChecksum(t *testing.T) {
+	content := strings.Repeat(fixture, 50)
+	compressed := gzipMembers(t, []string{content[:1000], content[1000:]}, true)
+	inputs := map[string]string{
+		"plain": writeFixture(t, content),
+		"gzip":  writeFixture(t, compressed),
+	}
+	want := map[string]map[string]string{
+		"plain": {
+			checksumCRC32:  fmt.Sprintf("%08x", crc32.ChecksumIEEE([]byte(content))),
+			checksumSHA256: fmt.Sprintf("%x", sha256.Sum256([]byte(content))),
+		},
+		"gzip": {
+			checksumCRC32:  fmt.Sprintf("%08x", crc32.ChecksumIEEE([]byte(compressed))),
+			checksumSHA256: fmt.Sprintf("%x", sha256.Sum256([]byte(compressed))),
+		},
+	}
+
+	for input, fileName := range inputs {
+		for _, engine := range []string{"stream", "mmap", "pread"} {
+			for _, algo := range []string{checksumCRC32, checksumSHA256} {
+				opts := Options{Checksum: algo}
+				// a small chunk size hashes the input over several reads
+				agg, err := run(fileName, engine, 1024, opts)
+				if err != nil {
+					t.Fatalf("%s %s %s: %v", input, engine, algo, err)
+				}
+				var stdout, stderr bytes.Buffer
+				if err := printResults(&stdout, &stderr, agg, &opts, true); err != nil {
+					t.Fatal(err)
+				}
+				if got, want := stderr.String(), algo+" "+want[input][algo]+"\n"; got != want {
+					t.Errorf("%s %s: printed %q, want %q", input, engine, got, want)
+				}
+			}
+		}
+	}
+
+	if err := validateChecksum("md5"); err == nil {
+		t.Error("unknown algorithm accepted")
+	}
+}
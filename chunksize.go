@@ -0,0 +1,30 @@
+package main
+
+import "runtime"
+
+// Bounds of adaptiveChunkSize. Below the minimum the per-chunk overhead of
+// the channel and the line cut shows, above the maximum the buffer pool of
+// the streaming engine, (chanSize + workers + 2) chunks, takes too much
+// memory.
+const (
+	minAdaptiveChunkSize = 256 * 1024
+	maxAdaptiveChunkSize = 32 * 1024 * 1024
+)
+
+// chunksPerWorker is how many chunks adaptiveChunkSize gives every worker,
+// so a worker done early finds more work instead of idling while the
+// others finish a last large chunk
+const chunksPerWorker = 4
+
+// adaptiveChunkSize picks the read size of the streaming engine for a file
+// of size bytes, when -chunk-size isn't given: the file split into
+// chunksPerWorker chunks per worker, clamped to [256 KiB, 32 MiB]. Small
+// files then don't allocate 16 MiB buffers for a few KiB of lines, and
+// large ones are cut into enough chunks to keep every worker busy.
+func adaptiveChunkSize(size int64, workers int) int {
+	if workers == 0 {
+		workers = min(max(runtime.NumCPU()-1, 1), workerCount)
+	}
+	chunk := size / int64(workers*chunksPerWorker)
+	return int(min(max(chunk, minAdaptiveChunkSize), maxAdaptiveChunkSize))
+}
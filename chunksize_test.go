@@ -0,0 +1,30 @@
+package main
+
+import "testing"
+
+func TestAdaptiveChunkSize(t *testing.T) {
+	for _, tc := range []struct {
+		size    int64
+		workers int
+		want    int
+	}{
+		{0, 4, minAdaptiveChunkSize},
+		{1 << 20, 4, minAdaptiveChunkSize},
+		{64 << 20, 4, 4 << 20},
+		{64 << 20, 8, 2 << 20},
+		{13 << 30, 4, maxAdaptiveChunkSize},
+	} {
+		if got := adaptiveChunkSize(tc.size, tc.workers); got != tc.want {
+			t.Errorf("adaptiveChunkSize(%d, %d) = %d, want %d", tc.size, tc.workers, got, tc.want)
+		}
+	}
+
+	// the streaming engine picks it for a chunk size of zero
+	agg, err := evaluate(writeFixture(t, fixture), workerCount, 0, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := string(appendResults(nil, agg, &Options{})); got != fixtureResult {
+		t.Errorf("got  %s\nwant %s", got, fixtureResult)
+	}
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"os/exec"
+	"strings"
+	"testing"
+)
+
+// runMainEnv makes the test binary run main instead of the tests, so
+// runCLI can check what the flags do
+const runMainEnv = "ONEBRC_RUN_MAIN"
+
+func TestMain(m *testing.M) {
+	if os.Getenv(runMainEnv) == "1" {
+		main()
+		os.Exit(0)
+	}
+	os.Exit(m.Run())
+}
+
+// runCLI runs the program with args and returns its stdout and stderr, and
+// whether it exited with 0
+func runCLI(t *testing.T, args ...string) (stdout, stderr string, ok bool) {
+	t.Helper()
+	var out, errOut bytes.Buffer
+	cmd := exec.Command(os.Args[0], args...)
+	cmd.Env = append(os.Environ(), runMainEnv+"=1")
+	cmd.Stdout, cmd.Stderr = &out, &errOut
+	err := cmd.Run()
+	if _, exited := err.(*exec.ExitError); err != nil && !exited {
+		t.Fatal(err)
+	}
+	return out.String(), errOut.String(), err == nil
+}
+
+func TestCLITop(t *testing.T) {
+	fileName := writeFixture(t, "A;1.0\nB;5.0\nC;3.0\nD;9.0\n")
+
+	tests := []struct {
+		args []string
+		want string
+	}{
+		// ranked, highest first, unless -sort or -order are given
+		{[]string{"-top", "2"}, "{D=9.0/9.0/9.0, B=5.0/5.0/5.0}\n"},
+		{[]string{"-top", "2", "-sort", "name"}, "{B=5.0/5.0/5.0, D=9.0/9.0/9.0}\n"},
+		{[]string{"-top", "2", "-order", "desc"}, "{D=9.0/9.0/9.0, B=5.0/5.0/5.0}\n"},
+		{nil, "{A=1.0/1.0/1.0, B=5.0/5.0/5.0, C=3.0/3.0/3.0, D=9.0/9.0/9.0}\n"},
+	}
+	for _, tt := range tests {
+		stdout, stderr, ok := runCLI(t, append(tt.args, fileName)...)
+		if !ok || stdout != tt.want {
+			t.Errorf("%q: got %q, stderr %q; want %q", tt.args, stdout, stderr, tt.want)
+		}
+	}
+}
+
+func TestCLIMultipleFiles(t *testing.T) {
+	first := writeFixture(t, "A;1.0\n")
+	second := writeFixture(t, "B;x\n")
+
+	for _, flag := range []string{"-validate", "-compare-engines"} {
+		if _, stderr, ok := runCLI(t, flag, first, second); ok || !strings.Contains(stderr, "single file") {
+			t.Errorf("%s with two files: ok %v, stderr %q; want it rejected", flag, ok, stderr)
+		}
+	}
+
+	_, stderr, _ := runCLI(t, first, second)
+	if want := second + `: line 1: "B;x"`; !strings.Contains(stderr, want) {
+		t.Errorf("stderr %q, want %q", stderr, want)
+	}
+}
@@ -0,0 +1,31 @@
+//go:build collate
+
+package main
+
+import (
+	"bytes"
+	"slices"
+
+	"golang.org/x/text/collate"
+	"golang.org/x/text/language"
+)
+
+// collateStationNames sorts names by the Unicode collation of the language
+// tag, for Options.Collate. Names the collation ranks equal, like ones that
+// differ only in ignorable characters, stay in byte order.
+func collateStationNames(names [][]byte, tag string) error {
+	c := collate.New(language.Make(tag))
+	slices.SortStableFunc(names, func(a, b []byte) int {
+		if n := c.Compare(a, b); n != 0 {
+			return n
+		}
+		return bytes.Compare(a, b)
+	})
+	return nil
+}
+
+// validateCollate checks the language tag passed with -collate
+func validateCollate(tag string) error {
+	_, err := language.Parse(tag)
+	return err
+}
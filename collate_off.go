@@ -0,0 +1,20 @@
+//go:build !collate
+
+package main
+
+import "errors"
+
+// errNoCollate is the error of Options.Collate in a build without the
+// collate tag, which keeps golang.org/x/text out of the binary
+var errNoCollate = errors.New("collation needs a build with -tags collate")
+
+// collateStationNames is the stand-in for the collate build, it leaves
+// names in byte order
+func collateStationNames(names [][]byte, tag string) error {
+	return errNoCollate
+}
+
+// validateCollate rejects every -collate without the collate build tag
+func validateCollate(tag string) error {
+	return errNoCollate
+}
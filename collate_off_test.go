@@ -0,0 +1,21 @@
+//go:build !collate
+
+package main
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestCollateNeedsBuildTag(t *testing.T) {
+	fileName := writeFixture(t, fixture)
+	if _, err := evaluateMmap(fileName, Options{Collate: "en"}); !errors.Is(err, errNoCollate) {
+		t.Errorf("Collate without the collate tag: %v", err)
+	}
+	if _, err := AggregateFiles([]string{fileName, fileName}, Options{Collate: "en"}); !errors.Is(err, errNoCollate) {
+		t.Errorf("AggregateFiles with Collate without the collate tag: %v", err)
+	}
+	if err := validateCollate("en"); !errors.Is(err, errNoCollate) {
+		t.Errorf("validateCollate = %v", err)
+	}
+}
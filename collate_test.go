@@ -0,0 +1,34 @@
+//go:build collate
+
+package main
+
+import "testing"
+
+func TestCollate(t *testing.T) {
+	fileName := writeFixture(t, "Zürich;1.0\nÅbo;2.0\nAarhus;3.0\nÉvora;4.0\nzagreb;5.0\nEssen;6.0\n")
+
+	tests := []struct {
+		collate string
+		want    string
+	}{
+		// bytes put lower case after upper case and accents after both
+		{"", "{Aarhus=3.0/3.0/3.0, Essen=6.0/6.0/6.0, Zürich=1.0/1.0/1.0, zagreb=5.0/5.0/5.0, Åbo=2.0/2.0/2.0, Évora=4.0/4.0/4.0}\n"},
+		{"en", "{Aarhus=3.0/3.0/3.0, Åbo=2.0/2.0/2.0, Essen=6.0/6.0/6.0, Évora=4.0/4.0/4.0, zagreb=5.0/5.0/5.0, Zürich=1.0/1.0/1.0}\n"},
+		// Swedish sorts Å after Z
+		{"sv", "{Aarhus=3.0/3.0/3.0, Essen=6.0/6.0/6.0, Évora=4.0/4.0/4.0, zagreb=5.0/5.0/5.0, Zürich=1.0/1.0/1.0, Åbo=2.0/2.0/2.0}\n"},
+	}
+	for _, tt := range tests {
+		opts := Options{Collate: tt.collate}
+		agg, err := evaluateMmap(fileName, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := string(appendResults(nil, agg, &opts)); got != tt.want {
+			t.Errorf("collate %q: got  %s\nwant %s", tt.collate, got, tt.want)
+		}
+	}
+
+	if err := validateCollate("not a language"); err == nil {
+		t.Error("validateCollate accepted garbage")
+	}
+}
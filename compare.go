@@ -0,0 +1,68 @@
+package main
+
+import (
+	"fmt"
+	"maps"
+	"reflect"
+	"slices"
+)
+
+// compareEngines aggregates fileName with every engine and returns an error
+// naming the first station on which one of them disagrees with mmap. It
+// returns the number of stations otherwise.
+func compareEngines(fileName string, chunkSize int, opts Options) (int, error) {
+	// run streams gzip files with every engine, leaving nothing to compare
+	gz, err := isGzipFile(fileName)
+	if err != nil {
+		return 0, err
+	}
+	if gz {
+		return 0, fmt.Errorf("can't compare engines on %s, it is gzip compressed and always streamed", fileName)
+	}
+
+	want, err := run(fileName, "mmap", chunkSize, opts)
+	if err != nil {
+		return 0, err
+	}
+	wantStats := want.stats(&opts)
+
+	for _, engine := range []string{"stream", "pread"} {
+		got, err := run(fileName, engine, chunkSize, opts)
+		if err != nil {
+			return 0, err
+		}
+		if err := diffStats(wantStats, got.stats(&opts)); err != nil {
+			return 0, fmt.Errorf("%s disagrees with mmap: %w", engine, err)
+		}
+		if !reflect.DeepEqual(got.report, want.report) {
+			return 0, fmt.Errorf("%s disagrees with mmap: report %+v, want %+v", engine, got.report, want.report)
+		}
+	}
+	return len(wantStats), nil
+}
+
+// diffStats returns an error naming the first station, in name order, that
+// differs between want and got
+func diffStats(want, got map[string]Stats) error {
+	names := slices.Sorted(maps.Keys(want))
+	for name := range got {
+		if _, ok := want[name]; !ok {
+			names = append(names, name)
+		}
+	}
+	slices.Sort(names)
+
+	for _, name := range names {
+		w, inWant := want[name]
+		g, inGot := got[name]
+		switch {
+		case !inGot:
+			return fmt.Errorf("station %q missing", name)
+		case !inWant:
+			return fmt.Errorf("unexpected station %q", name)
+		case g != w:
+			return fmt.Errorf("station %q has %v from %d readings, want %v from %d", name, g, g.count, w, w.count)
+		}
+	}
+	return nil
+}
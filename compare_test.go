@@ -0,0 +1,43 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestCompareEngines(t *testing.T) {
+	fileName := writeFixture(t, fixture+"garbage\nHamburg;1.0\n")
+
+	for _, opts := range []Options{{}, {TrackLines: true}, {SkipHeader: true, FoldCase: true}} {
+		stations, err := compareEngines(fileName, 32, opts)
+		if err != nil {
+			t.Errorf("%+v: %v", opts, err)
+		}
+		if stations != 9 {
+			t.Errorf("%+v: compared %d stations, want 9", opts, stations)
+		}
+	}
+}
+
+func TestDiffStats(t *testing.T) {
+	want := map[string]Stats{"a": {count: 1, min: 1, max: 1, sum: 1}, "b": {count: 1, min: 2, max: 2, sum: 2}}
+
+	tests := []struct {
+		got     map[string]Stats
+		wantErr string
+	}{
+		{map[string]Stats{"a": {count: 1, min: 1, max: 1, sum: 1}, "b": {count: 1, min: 2, max: 2, sum: 2}}, ""},
+		{map[string]Stats{"a": {count: 1, min: 1, max: 1, sum: 1}}, `station "b" missing`},
+		{map[string]Stats{"a": {count: 2, min: 1, max: 1, sum: 2}, "b": {count: 1, min: 3, max: 3, sum: 3}}, `station "a" has`},
+		{map[string]Stats{"a": {count: 1, min: 1, max: 1, sum: 1}, "aa": {}, "b": {count: 1, min: 2, max: 2, sum: 2}}, `unexpected station "aa"`},
+	}
+	for _, tt := range tests {
+		err := diffStats(want, tt.got)
+		switch {
+		case tt.wantErr == "" && err != nil:
+			t.Errorf("got error %v", err)
+		case tt.wantErr != "" && (err == nil || !strings.HasPrefix(err.Error(), tt.wantErr)):
+			t.Errorf("err = %v, want %s", err, tt.wantErr)
+		}
+	}
+}
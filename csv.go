@@ -0,0 +1,53 @@
+package main
+
+import (
+	"encoding/csv"
+	"io"
+	"strconv"
+)
+
+// writeCSV writes entries as CSV with a header row:
+//
+//	station,min,mean,max,count
+//
+// Options.Exact adds a sum column, Options.TrackLines first_line and
+// last_line columns.
+func writeCSV(w io.Writer, entries []stationResult, opts *Options) error {
+	cw := csv.NewWriter(w)
+
+	header := []string{"station", "min", "mean", "max", "count"}
+	if opts.Exact {
+		header = append(header, "sum")
+	}
+	if opts.TrackLines {
+		header = append(header, "first_line", "last_line")
+	}
+	if err := cw.Write(header); err != nil {
+		return err
+	}
+
+	record := make([]string, len(header))
+	buf := make([]byte, 0, 32)
+	for _, entry := range entries {
+		record[0] = string(entry.name)
+		record[1] = string(appendTemperature(buf[:0], entry.result.min, opts))
+		record[2] = string(appendMean(buf[:0], entry.result, opts))
+		record[3] = string(appendTemperature(buf[:0], entry.result.max, opts))
+		record[4] = strconv.FormatInt(entry.result.count, 10)
+		column := 5
+		if opts.Exact {
+			record[column] = string(appendSum(buf[:0], entry.result))
+			column++
+		}
+		if opts.TrackLines {
+			record[column] = strconv.FormatInt(entry.lines.first, 10)
+			record[column+1] = strconv.FormatInt(entry.lines.last, 10)
+		}
+		if err := cw.Write(record); err != nil {
+			return err
+		}
+	}
+
+	cw.Flush()
+	return cw.Error()
+}
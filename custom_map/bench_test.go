@@ -0,0 +1,111 @@
+package custom_map
+
+import (
+	"fmt"
+	"testing"
+)
+
+func stationPairs(n int) []RHKeyValuePair {
+	pairs := make([]RHKeyValuePair, n)
+	for i := range pairs {
+		pairs[i] = RHKeyValuePair{Key: fmt.Sprintf("station-%d", i), Value: i}
+	}
+	return pairs
+}
+
+func BenchmarkPutAll(b *testing.B) {
+	pairs := stationPairs(10_000)
+
+	b.Run("PutAll", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			rhm := NewRobinHoodMap(16)
+			rhm.PutAll(pairs)
+		}
+	})
+
+	b.Run("Put", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			rhm := NewRobinHoodMap(16)
+			for _, pair := range pairs {
+				rhm.Put(pair.Key, pair.Value)
+			}
+		}
+	})
+}
+
+// BenchmarkProbing fills maps to a 0.9 load factor and reports the average
+// probe distance of their entries next to the cost of a lookup
+func BenchmarkProbing(b *testing.B) {
+	const size = 1 << 14
+	pairs := stationPairs(size * 9 / 10)
+
+	for _, probing := range []struct {
+		name    string
+		probing Probing
+	}{
+		{"linear", LinearProbing},
+		{"triangular", TriangularProbing},
+	} {
+		b.Run(probing.name, func(b *testing.B) {
+			rhm := NewRobinHoodMapWithProbing(size, probing.probing)
+			if err := rhm.SetMaxLoadFactor(0.9); err != nil {
+				b.Fatal(err)
+			}
+			rhm.PutAll(pairs)
+
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if _, ok := rhm.Get(pairs[i%len(pairs)].Key); !ok {
+					b.Fatal("key missing")
+				}
+			}
+
+			var total, count int
+			for distance, n := range rhm.ProbeHistogram() {
+				total += distance * n
+				count += n
+			}
+			b.ReportMetric(float64(total)/float64(count), "probes/key")
+			b.ReportMetric(rhm.LoadFactor(), "load")
+		})
+	}
+}
+
+// BenchmarkAdversarialKeys looks up 1000 keys whose planted hashes collide
+// in their low bits, next to the same keys under the real hash, and reports
+// the longest probe and the table size the collisions drove the map to
+func BenchmarkAdversarialKeys(b *testing.B) {
+	const n = 1000
+	for _, pattern := range []struct {
+		name string
+		hash func(i int) uint32
+	}{
+		{"fnv", nil},
+		{"low bits", func(i int) uint32 { return uint32(i) << 12 }},
+		{"sixteen hashes", func(i int) uint32 { return uint32(i%16) << 8 }},
+	} {
+		b.Run(pattern.name, func(b *testing.B) {
+			keys := make([]string, n)
+			for i := range keys {
+				keys[i] = fmt.Sprintf("key-%d", i)
+			}
+			rhm := NewRobinHoodMap(16)
+			if pattern.hash != nil {
+				_, rhm.hash = adversarialKeys(n, pattern.hash)
+			}
+			for i, key := range keys {
+				rhm.Put(key, i)
+			}
+
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if _, ok := rhm.Get(keys[i%n]); !ok {
+					b.Fatal("key missing")
+				}
+			}
+
+			b.ReportMetric(float64(len(rhm.ProbeHistogram())-1), "max-distance")
+			b.ReportMetric(float64(rhm.size), "slots")
+		})
+	}
+}
@@ -3,33 +3,75 @@ package custom_map
 import (
 	"fmt"
 	"hash/fnv"
+	"slices"
+	"strings"
+)
+
+const (
+	minSize              = 16
+	defaultMinLoadFactor = 0.15
+	defaultMaxLoadFactor = 0.75
 )
 
 // Robin Hood hash map implementation for better cache performance
 type RobinHoodMap struct {
-	entries []Entry
-	size    int
-	count   int
-	mask    int // size - 1, for fast modulo when size is power of 2
+	entries    []Entry
+	size       int
+	count      int
+	tombstones int // Deleted slots not yet reclaimed by a resize
+	mask       int // size - 1, for fast modulo when size is power of 2
+	
+	// Delete halves the table once the load factor drops below this value
+	minLoadFactor float64
+	// Inserts double the table once the load factor exceeds this value
+	maxLoadFactor float64
+	
+	probing Probing
+	
+	// hash replaces fastHash when set, so tests can craft colliding keys
+	hash func(key string) uint32
 }
 
+// Probing is the probe sequence a RobinHoodMap follows from the ideal slot
+// of a key
+type Probing int
+
+const (
+	// LinearProbing steps to the next slot, the default
+	LinearProbing Probing = iota
+	// TriangularProbing steps 1, 2, 3, ... slots, so an entry at distance d
+	// sits d(d+1)/2 slots past its ideal one. It breaks up the clusters
+	// linear probing builds under high load and still visits every slot of
+	// a power of 2 table.
+	TriangularProbing
+)
+
+// Entry is a slot of the map. Distance used to be an int8, which more than
+// 128 keys sharing a hash overflowed; code reading it needs int32 now.
 type Entry struct {
 	Key      string
 	Value    interface{}
 	Hash     uint32
-	Distance int8 // Distance from ideal position
+	Distance int32 // Distance from ideal position, less than the table size
 	Empty    bool
+	Deleted  bool // Tombstone left behind by Delete
 }
 
 // NewRobinHoodMap creates a new Robin Hood hash map
 func NewRobinHoodMap(initialSize int) *RobinHoodMap {
+	return NewRobinHoodMapWithProbing(initialSize, LinearProbing)
+}
+
+// NewRobinHoodMapWithProbing creates a new Robin Hood hash map that probes
+// with the given strategy
+func NewRobinHoodMapWithProbing(initialSize int, probing Probing) *RobinHoodMap {
 	// Ensure size is power of 2 for fast modulo
 	size := 1
 	for size < initialSize {
 		size <<= 1
 	}
-	if size < 16 {
-		size = 16
+	if size < minSize {
+		size = minSize
 	}
 	
 	entries := make([]Entry, size)
@@ -38,15 +80,63 @@ func NewRobinHoodMap(initialSize int) *RobinHoodMap {
 	}
 	
 	return &RobinHoodMap{
-		entries: entries,
-		size:    size,
-		count:   0,
-		mask:    size - 1,
+		entries:       entries,
+		size:          size,
+		count:         0,
+		mask:          size - 1,
+		minLoadFactor: defaultMinLoadFactor,
+		maxLoadFactor: defaultMaxLoadFactor,
+		probing:       probing,
 	}
 }
 
+// FromGoMap creates a Robin Hood hash map holding all pairs of m, sized up
+// front so filling it never resizes
+func FromGoMap(m map[string]interface{}) *RobinHoodMap {
+	rhm := NewRobinHoodMap(16)
+	rhm.reserve(len(m))
+	
+	for key, value := range m {
+		rhm.Put(key, value)
+	}
+	
+	return rhm
+}
+
+// SetMinLoadFactor sets the load factor below which Delete shrinks the map.
+// Zero disables shrinking. f must be in [0, max/2) for the max load factor,
+// so the halved table still has room for what is left.
+func (rhm *RobinHoodMap) SetMinLoadFactor(f float64) error {
+	if f < 0 || f >= rhm.maxLoadFactor/2 {
+		return fmt.Errorf("min load factor %v out of range [0, %v)", f, rhm.maxLoadFactor/2)
+	}
+	
+	rhm.minLoadFactor = f
+	return nil
+}
+
+// SetMaxLoadFactor sets the load factor above which inserts grow the map.
+// Lower values shorten probes, higher values save memory. f must be in (0, 1)
+// and more than twice the min load factor.
+func (rhm *RobinHoodMap) SetMaxLoadFactor(f float64) error {
+	if f <= 0 || f >= 1 {
+		return fmt.Errorf("max load factor %v out of range (0, 1)", f)
+	}
+	if rhm.minLoadFactor >= f/2 {
+		return fmt.Errorf("max load factor %v not more than twice the min load factor %v", f, rhm.minLoadFactor)
+	}
+	
+	rhm.maxLoadFactor = f
+	rhm.reserve(rhm.count)
+	return nil
+}
+
 // fastHash uses a simple but fast hash function
 func (rhm *RobinHoodMap) fastHash(key string) uint32 {
+	if rhm.hash != nil {
+		return rhm.hash(key)
+	}
+	
 	h := fnv.New32a()
 	h.Write([]byte(key))
 	return h.Sum32()
@@ -54,175 +144,317 @@ func (rhm *RobinHoodMap) fastHash(key string) uint32 {
 
 // Put inserts or updates a key-value pair using Robin Hood hashing
 func (rhm *RobinHoodMap) Put(key string, value interface{}) {
-	if float64(rhm.count)/float64(rhm.size) > 0.75 {
+	hash := rhm.fastHash(key)
+	
+	if pos, ok := rhm.find(key, hash); ok {
+		// Update existing key
+		rhm.entries[pos].Value = value
+		return
+	}
+	
+	// Tombstones occupy slots too, so they count towards the load factor
+	if float64(rhm.count+rhm.tombstones)/float64(rhm.size) > rhm.maxLoadFactor {
+		rhm.resize()
+	}
+	
+	rhm.insert(Entry{
+		Key:   key,
+		Value: value,
+		Hash:  hash,
+		Empty: false,
+	})
+}
+
+// PutAll inserts or updates every pair, reserving room for all of them up
+// front so the batch triggers at most one resize. Later pairs overwrite
+// earlier ones with the same key.
+func (rhm *RobinHoodMap) PutAll(pairs []RHKeyValuePair) {
+	rhm.reserve(rhm.count + len(pairs))
+	
+	for _, pair := range pairs {
+		rhm.Put(pair.Key, pair.Value)
+	}
+}
+
+// reserve grows the table so n entries fit under the max load factor
+func (rhm *RobinHoodMap) reserve(n int) {
+	size := rhm.size
+	for float64(n)/float64(size) > rhm.maxLoadFactor {
+		size <<= 1
+	}
+	
+	if size != rhm.size {
+		rhm.rehash(size)
+	}
+}
+
+// ShrinkToFit rebuilds the table at the smallest power of 2 size that holds
+// the current entries under the max load factor, dropping tombstones. Call
+// it once no more inserts are expected, to save memory and shorten probes.
+func (rhm *RobinHoodMap) ShrinkToFit() {
+	size := minSize
+	for float64(rhm.count)/float64(size) > rhm.maxLoadFactor {
+		size <<= 1
+	}
+	
+	if size != rhm.size || rhm.tombstones > 0 {
+		rhm.rehash(size)
+	}
+}
+
+// GetOrInsert returns the value stored under key, or stores and returns the
+// result of create if the key is absent. The lookup and the insert share a
+// single probe; create is only called for new keys.
+func (rhm *RobinHoodMap) GetOrInsert(key string, create func() interface{}) (value interface{}, existed bool) {
+	if float64(rhm.count+rhm.tombstones)/float64(rhm.size) > rhm.maxLoadFactor {
 		rhm.resize()
 	}
 	
 	hash := rhm.fastHash(key)
 	pos := int(hash) & rhm.mask
-	distance := int8(0)
+	distance := int32(0)
 	
-	entry := Entry{
-		Key:      key,
-		Value:    value,
-		Hash:     hash,
-		Distance: distance,
-		Empty:    false,
+	for {
+		entry := &rhm.entries[pos]
+		
+		if entry.Empty || distance > entry.Distance {
+			// The Robin Hood invariant proves the key is absent, and this
+			// is exactly where the insert would have to start
+			value = create()
+			rhm.insertAt(Entry{Key: key, Value: value, Hash: hash}, pos, distance)
+			return value, false
+		}
+		
+		if !entry.Deleted && entry.Hash == hash && entry.Key == key {
+			return entry.Value, true
+		}
+		
+		pos = rhm.next(pos, distance)
+		distance++
+	}
+}
+
+// Upsert stores the result of update under key. update gets the value
+// stored so far, or nil and false if the key is absent, so accumulating
+// into a map entry takes a single probe instead of a Get and a Put.
+func (rhm *RobinHoodMap) Upsert(key string, update func(old interface{}, existed bool) interface{}) {
+	if float64(rhm.count+rhm.tombstones)/float64(rhm.size) > rhm.maxLoadFactor {
+		rhm.resize()
 	}
 	
+	hash := rhm.fastHash(key)
+	pos := int(hash) & rhm.mask
+	distance := int32(0)
+	
+	for {
+		entry := &rhm.entries[pos]
+		
+		if entry.Empty || distance > entry.Distance {
+			// Absent, and this is where the insert starts, as in GetOrInsert
+			rhm.insertAt(Entry{Key: key, Value: update(nil, false), Hash: hash}, pos, distance)
+			return
+		}
+		
+		if !entry.Deleted && entry.Hash == hash && entry.Key == key {
+			entry.Value = update(entry.Value, true)
+			return
+		}
+		
+		pos = rhm.next(pos, distance)
+		distance++
+	}
+}
+
+// insert places an entry whose key is known to be absent from the map
+func (rhm *RobinHoodMap) insert(entry Entry) {
+	rhm.insertAt(entry, int(entry.Hash)&rhm.mask, 0)
+}
+
+// insertAt continues the insert probe of entry from pos, where it is
+// distance slots away from its ideal position
+func (rhm *RobinHoodMap) insertAt(entry Entry, pos int, distance int32) {
 	for {
 		existing := &rhm.entries[pos]
 		
 		if existing.Empty {
 			// Found empty slot
+			entry.Distance = distance
 			*existing = entry
 			rhm.count++
 			return
 		}
 		
-		if existing.Hash == hash && existing.Key == key {
-			// Update existing key
-			existing.Value = value
+		if existing.Deleted && distance >= existing.Distance {
+			// Reuse the tombstone: lookups passing through this slot still
+			// stop no earlier than they did before
+			entry.Distance = distance
+			*existing = entry
+			rhm.count++
+			rhm.tombstones--
 			return
 		}
 		
 		// Robin Hood: if our distance is greater than existing entry's distance,
 		// swap and continue with the displaced entry
-		if distance > existing.Distance {
+		if !existing.Deleted && distance > existing.Distance {
+			entry.Distance = distance
 			entry, *existing = *existing, entry
-			distance = existing.Distance
+			distance = entry.Distance
 		}
 		
-		pos = (pos + 1) & rhm.mask
+		pos = rhm.next(pos, distance)
 		distance++
-		
-		// Prevent infinite loop (should not happen with proper resizing)
-		if distance > 127 {
-			rhm.resize()
-			rhm.Put(key, value) // Retry after resize
-			return
-		}
 	}
 }
 
-// Get retrieves a value by key
-func (rhm *RobinHoodMap) Get(key string) (interface{}, bool) {
-	hash := rhm.fastHash(key)
+// next returns the slot after pos in the probe sequence of an entry that
+// is distance probes away from its ideal slot at pos. The step depends on
+// nothing but the distance, so an entry displaced by the Robin Hood swap
+// carries on along its own sequence.
+func (rhm *RobinHoodMap) next(pos int, distance int32) int {
+	if rhm.probing == TriangularProbing {
+		return (pos + int(distance) + 1) & rhm.mask
+	}
+	return (pos + 1) & rhm.mask
+}
+
+// find returns the slot holding key, skipping over tombstones
+func (rhm *RobinHoodMap) find(key string, hash uint32) (int, bool) {
 	pos := int(hash) & rhm.mask
-	distance := int8(0)
+	distance := int32(0)
 	
 	for {
 		entry := &rhm.entries[pos]
 		
 		if entry.Empty || distance > entry.Distance {
 			// Key not found
-			return nil, false
+			return 0, false
 		}
 		
-		if entry.Hash == hash && entry.Key == key {
-			return entry.Value, true
+		if !entry.Deleted && entry.Hash == hash && entry.Key == key {
+			return pos, true
 		}
 		
-		pos = (pos + 1) & rhm.mask
+		pos = rhm.next(pos, distance)
 		distance++
-		
-		if distance > 127 {
-			break
-		}
+	}
+}
+
+// Get retrieves a value by key
+func (rhm *RobinHoodMap) Get(key string) (interface{}, bool) {
+	pos, ok := rhm.find(key, rhm.fastHash(key))
+	if !ok {
+		return nil, false
 	}
 	
-	return nil, false
+	return rhm.entries[pos].Value, true
 }
 
-// Delete removes a key-value pair
+// Contains reports whether key is present, without fetching its value
+func (rhm *RobinHoodMap) Contains(key string) bool {
+	_, ok := rhm.find(key, rhm.fastHash(key))
+	return ok
+}
+
+// Delete removes a key-value pair by replacing it with a tombstone. The
+// tombstone keeps its distance so probe sequences running through the slot
+// are not cut short; it is reused by later inserts or dropped on resize.
 func (rhm *RobinHoodMap) Delete(key string) bool {
-	hash := rhm.fastHash(key)
-	pos := int(hash) & rhm.mask
-	distance := int8(0)
+	pos, ok := rhm.find(key, rhm.fastHash(key))
+	if !ok {
+		return false // Key not found
+	}
 	
-	for {
-		entry := &rhm.entries[pos]
-		
-		if entry.Empty || distance > entry.Distance {
-			return false // Key not found
-		}
-		
-		if entry.Hash == hash && entry.Key == key {
-			// Found the key to delete
-			rhm.count--
-			
-			// Shift subsequent entries back to fill the gap
-			for {
-				nextPos := (pos + 1) & rhm.mask
-				nextEntry := &rhm.entries[nextPos]
-				
-				if nextEntry.Empty || nextEntry.Distance == 0 {
-					break
-				}
-				
-				// Move the next entry back
-				rhm.entries[pos] = *nextEntry
-				rhm.entries[pos].Distance--
-				pos = nextPos
-			}
-			
-			// Mark the final position as empty
-			rhm.entries[pos].Empty = true
-			rhm.entries[pos].Key = ""
-			rhm.entries[pos].Value = nil
-			
-			return true
-		}
-		
-		pos = (pos + 1) & rhm.mask
-		distance++
-		
-		if distance > 127 {
-			break
-		}
+	entry := &rhm.entries[pos]
+	entry.Deleted = true
+	entry.Key = ""
+	entry.Value = nil
+	
+	rhm.count--
+	rhm.tombstones++
+	
+	if rhm.size > minSize && rhm.LoadFactor() < rhm.minLoadFactor {
+		rhm.rehash(rhm.size / 2)
 	}
 	
-	return false
+	return true
 }
 
 // resize doubles the size and rehashes all elements
 func (rhm *RobinHoodMap) resize() {
+	rhm.rehash(rhm.size * 2)
+}
+
+// rehash moves all live elements into a table of the given power of 2 size
+func (rhm *RobinHoodMap) rehash(size int) {
 	oldEntries := rhm.entries
 	
-	rhm.size *= 2
+	rhm.size = size
 	rhm.mask = rhm.size - 1
 	rhm.entries = make([]Entry, rhm.size)
 	rhm.count = 0
+	rhm.tombstones = 0
 	
 	for i := range rhm.entries {
 		rhm.entries[i].Empty = true
 	}
 	
-	// Rehash all existing entries
+	// Rehash all live entries, dropping tombstones
 	for _, entry := range oldEntries {
-		if !entry.Empty {
-			rhm.Put(entry.Key, entry.Value)
+		if entry.live() {
+			rhm.insert(entry)
 		}
 	}
 }
 
+// Clone returns an independent copy of the map. Values are copied as-is,
+// so pointer values are still shared between the original and the clone
+func (rhm *RobinHoodMap) Clone() *RobinHoodMap {
+	entries := make([]Entry, len(rhm.entries))
+	copy(entries, rhm.entries)
+	
+	return &RobinHoodMap{
+		entries:    entries,
+		size:       rhm.size,
+		count:      rhm.count,
+		tombstones: rhm.tombstones,
+		mask:       rhm.mask,
+		
+		minLoadFactor: rhm.minLoadFactor,
+		maxLoadFactor: rhm.maxLoadFactor,
+		probing:       rhm.probing,
+		hash:          rhm.hash,
+	}
+}
+
+// live reports whether the slot holds a key, as opposed to being empty or a tombstone
+func (e *Entry) live() bool {
+	return !e.Empty && !e.Deleted
+}
+
 // Size returns the number of elements
 func (rhm *RobinHoodMap) Size() int {
 	return rhm.count
 }
 
+// Len returns the number of elements, like Size
+func (rhm *RobinHoodMap) Len() int {
+	return rhm.count
+}
+
 // LoadFactor returns current load factor
 func (rhm *RobinHoodMap) LoadFactor() float64 {
 	return float64(rhm.count) / float64(rhm.size)
 }
 
-// Stats returns debugging information
-func (rhm *RobinHoodMap) Stats() (int, int, float64, int8) {
-	maxDistance := int8(0)
+// Stats returns debugging information: the count, the size, the load
+// factor and the max probe distance, an int32 like Entry.Distance
+func (rhm *RobinHoodMap) Stats() (int, int, float64, int32) {
+	maxDistance := int32(0)
 	totalDistance := 0
 	
 	for _, entry := range rhm.entries {
-		if !entry.Empty {
+		if entry.live() {
 			if entry.Distance > maxDistance {
 				maxDistance = entry.Distance
 			}
@@ -241,11 +473,29 @@ func (rhm *RobinHoodMap) Stats() (int, int, float64, int8) {
 	return rhm.count, rhm.size, rhm.LoadFactor(), maxDistance
 }
 
+// ProbeHistogram returns how many entries sit at each probe distance from
+// their ideal slot, indexed by distance. A long tail points at clustering
+// from a poor hash or a too high load factor.
+func (rhm *RobinHoodMap) ProbeHistogram() []int {
+	var histogram []int
+	
+	for _, entry := range rhm.entries {
+		if entry.live() {
+			for len(histogram) <= int(entry.Distance) {
+				histogram = append(histogram, 0)
+			}
+			histogram[entry.Distance]++
+		}
+	}
+	
+	return histogram
+}
+
 // Keys returns all keys
 func (rhm *RobinHoodMap) Keys() []string {
 	keys := make([]string, 0, rhm.count)
 	for _, entry := range rhm.entries {
-		if !entry.Empty {
+		if entry.live() {
 			keys = append(keys, entry.Key)
 		}
 	}
@@ -256,7 +506,7 @@ func (rhm *RobinHoodMap) Keys() []string {
 func (rhm *RobinHoodMap) Values() []interface{} {
 	values := make([]interface{}, 0, rhm.count)
 	for _, entry := range rhm.entries {
-		if !entry.Empty {
+		if entry.live() {
 			values = append(values, entry.Value)
 		}
 	}
@@ -272,7 +522,7 @@ type RHKeyValuePair struct {
 func (rhm *RobinHoodMap) Entries() []RHKeyValuePair {
 	entries := make([]RHKeyValuePair, 0, rhm.count)
 	for _, entry := range rhm.entries {
-		if !entry.Empty {
+		if entry.live() {
 			entries = append(entries, RHKeyValuePair{
 				Key:   entry.Key,
 				Value: entry.Value,
@@ -282,10 +532,26 @@ func (rhm *RobinHoodMap) Entries() []RHKeyValuePair {
 	return entries
 }
 
+// SortedKeys returns all keys in ascending byte order
+func (rhm *RobinHoodMap) SortedKeys() []string {
+	keys := rhm.Keys()
+	slices.Sort(keys)
+	return keys
+}
+
+// EntriesSorted returns all key-value pairs ordered by key
+func (rhm *RobinHoodMap) EntriesSorted() []RHKeyValuePair {
+	entries := rhm.Entries()
+	slices.SortFunc(entries, func(a, b RHKeyValuePair) int {
+		return strings.Compare(a.Key, b.Key)
+	})
+	return entries
+}
+
 // ForEach iterates through all key-value pairs with a callback function
 func (rhm *RobinHoodMap) ForEach(fn func(key string, value interface{})) {
 	for _, entry := range rhm.entries {
-		if !entry.Empty {
+		if entry.live() {
 			fn(entry.Key, entry.Value)
 		}
 	}
@@ -294,7 +560,7 @@ func (rhm *RobinHoodMap) ForEach(fn func(key string, value interface{})) {
 // ForEachBreakable allows early termination by returning true from callback
 func (rhm *RobinHoodMap) ForEachBreakable(fn func(key string, value interface{}) bool) {
 	for _, entry := range rhm.entries {
-		if !entry.Empty {
+		if entry.live() {
 			if fn(entry.Key, entry.Value) {
 				return // Early termination
 			}
@@ -302,6 +568,16 @@ func (rhm *RobinHoodMap) ForEachBreakable(fn func(key string, value interface{})
 	}
 }
 
+// ForEachEntry calls fn with every live slot, including its hash and probe
+// distance, for diagnostics. Empty slots and tombstones are skipped.
+func (rhm *RobinHoodMap) ForEachEntry(fn func(e Entry)) {
+	for _, entry := range rhm.entries {
+		if entry.live() {
+			fn(entry)
+		}
+	}
+}
+
 // Iterator provides a more Go-like iteration pattern
 type RobinHoodIterator struct {
 	rhm   *RobinHoodMap
@@ -315,7 +591,7 @@ func (rhm *RobinHoodMap) Iterator() *RobinHoodIterator {
 func (iter *RobinHoodIterator) Next() bool {
 	iter.index++
 	for iter.index < len(iter.rhm.entries) {
-		if !iter.rhm.entries[iter.index].Empty {
+		if iter.rhm.entries[iter.index].live() {
 			return true
 		}
 		iter.index++
@@ -0,0 +1,688 @@
+package custom_map
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+	"testing"
+)
+
+func TestClone(t *testing.T) {
+	original := NewRobinHoodMap(16)
+	for i := 0; i < 100; i++ {
+		original.Put(fmt.Sprintf("station-%d", i), i)
+	}
+
+	clone := original.Clone()
+
+	clone.Put("station-0", -1)
+	clone.Put("clone-only", 1)
+	original.Put("station-1", -1)
+	original.Put("original-only", 1)
+
+	if v, _ := original.Get("station-0"); v != 0 {
+		t.Errorf("original station-0 = %v, want 0", v)
+	}
+	if v, _ := clone.Get("station-1"); v != 1 {
+		t.Errorf("clone station-1 = %v, want 1", v)
+	}
+	if _, ok := original.Get("clone-only"); ok {
+		t.Error("original sees key inserted into clone")
+	}
+	if _, ok := clone.Get("original-only"); ok {
+		t.Error("clone sees key inserted into original")
+	}
+	if original.Size() != 101 || clone.Size() != 101 {
+		t.Errorf("sizes = %d/%d, want 101/101", original.Size(), clone.Size())
+	}
+
+	for i := 2; i < 100; i++ {
+		key := fmt.Sprintf("station-%d", i)
+		if v, ok := clone.Get(key); !ok || v != i {
+			t.Errorf("clone %s = %v, %v; want %d", key, v, ok, i)
+		}
+	}
+}
+
+// keysWithIdealSlot returns n distinct keys that all hash to slot in a map of
+// the given size, forcing them into one displaced probe chain.
+func keysWithIdealSlot(rhm *RobinHoodMap, slot, n int) []string {
+	keys := make([]string, 0, n)
+	for i := 0; len(keys) < n; i++ {
+		key := fmt.Sprintf("key-%d", i)
+		if int(rhm.fastHash(key))&rhm.mask == slot {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+// adversarialKeys returns n keys and a hash for them that gives key i the
+// hash of hash(i), to plant in RobinHoodMap.hash
+func adversarialKeys(n int, hash func(i int) uint32) ([]string, func(string) uint32) {
+	keys := make([]string, n)
+	hashes := make(map[string]uint32, n)
+	for i := range keys {
+		keys[i] = fmt.Sprintf("key-%d", i)
+		hashes[keys[i]] = hash(i)
+	}
+	return keys, func(key string) uint32 { return hashes[key] }
+}
+
+// TestPutStoresDistance checks the probe distance Put stores in every
+// entry, including entries moved on by a Robin Hood swap
+func TestPutStoresDistance(t *testing.T) {
+	for _, probing := range []Probing{LinearProbing, TriangularProbing} {
+		// three ideal slots, so later keys displace earlier ones
+		keys, hash := adversarialKeys(60, func(i int) uint32 { return uint32(i % 3 * 5) })
+		rhm := NewRobinHoodMapWithProbing(16, probing)
+		rhm.hash = hash
+		for i, key := range keys {
+			rhm.Put(key, i)
+		}
+
+		for slot, e := range rhm.entries {
+			if e.Empty {
+				continue
+			}
+			pos := int(e.Hash) & rhm.mask
+			for d := int32(0); d < e.Distance; d++ {
+				pos = rhm.next(pos, d)
+			}
+			if pos != slot {
+				t.Errorf("probing %d: %s in slot %d, its distance %d leads to %d", probing, e.Key, slot, e.Distance, pos)
+			}
+		}
+	}
+}
+
+func TestAdversarialHash(t *testing.T) {
+	tests := []struct {
+		name string
+		n    int
+		hash func(i int) uint32
+		// size the table ends at: the load factor alone decides it, no
+		// matter how long the chains get
+		size int
+	}{
+		// four hash values, so all keys pile up in one cluster
+		{"four hashes", 120, func(i int) uint32 { return uint32(i % 4) }, 256},
+		// every key has a hash of its own, but the same ideal slot in
+		// tables of up to 4096 slots
+		{"shared low bits", 300, func(i int) uint32 { return uint32(i) << 12 }, 512},
+		// no resize can split keys that share the whole hash
+		{"one hash", 300, func(i int) uint32 { return 7 }, 512},
+	}
+
+	for _, tt := range tests {
+		for _, probing := range []Probing{LinearProbing, TriangularProbing} {
+			keys, hash := adversarialKeys(tt.n, tt.hash)
+			rhm := NewRobinHoodMapWithProbing(16, probing)
+			rhm.hash = hash
+			for i, key := range keys {
+				rhm.Put(key, i)
+			}
+
+			if rhm.size != tt.size {
+				t.Errorf("%s, probing %d: table of %d slots, want %d", tt.name, probing, rhm.size, tt.size)
+			}
+			if histogram := rhm.ProbeHistogram(); len(histogram) > tt.n {
+				t.Errorf("%s, probing %d: max distance %d", tt.name, probing, len(histogram)-1)
+			}
+
+			// every other key deleted, the rest still reachable past the
+			// tombstones
+			for i, key := range keys {
+				if i%2 == 0 && !rhm.Delete(key) {
+					t.Fatalf("%s, probing %d: Delete(%s) found nothing", tt.name, probing, key)
+				}
+			}
+			for i, key := range keys {
+				v, ok := rhm.Get(key)
+				if deleted := i%2 == 0; ok == deleted || (ok && v != i) {
+					t.Errorf("%s, probing %d: Get(%s) = %v, %v after deleting every other key", tt.name, probing, key, v, ok)
+				}
+			}
+			for i, key := range keys {
+				rhm.Put(key, -i)
+			}
+			for i, key := range keys {
+				if v, ok := rhm.Get(key); !ok || v != -i {
+					t.Errorf("%s, probing %d: Get(%s) = %v, %v after putting all back, want %d", tt.name, probing, key, v, ok, -i)
+				}
+			}
+			if rhm.Size() != tt.n {
+				t.Errorf("%s, probing %d: Size() = %d, want %d", tt.name, probing, rhm.Size(), tt.n)
+			}
+		}
+	}
+}
+
+func TestDeleteKeepsDisplacedKeysReachable(t *testing.T) {
+	rhm := NewRobinHoodMap(16)
+
+	// The chain starts in the last slot, so it wraps around to the front
+	keys := keysWithIdealSlot(rhm, rhm.mask, 6)
+	for i, key := range keys {
+		rhm.Put(key, i)
+	}
+
+	if !rhm.Delete(keys[0]) {
+		t.Fatalf("Delete(%q) = false, want true", keys[0])
+	}
+	if rhm.Delete(keys[0]) {
+		t.Fatalf("second Delete(%q) = true, want false", keys[0])
+	}
+	if _, ok := rhm.Get(keys[0]); ok {
+		t.Fatalf("Get(%q) found a deleted key", keys[0])
+	}
+
+	for i, key := range keys[1:] {
+		if v, ok := rhm.Get(key); !ok || v != i+1 {
+			t.Errorf("Get(%q) = %v, %v; want %d, true", key, v, ok, i+1)
+		}
+	}
+	if rhm.Size() != len(keys)-1 {
+		t.Errorf("Size() = %d, want %d", rhm.Size(), len(keys)-1)
+	}
+
+	// Re-inserting reuses the tombstone without duplicating the key
+	rhm.Put(keys[0], 100)
+	rhm.Put(keys[0], 101)
+	if v, ok := rhm.Get(keys[0]); !ok || v != 101 {
+		t.Errorf("Get(%q) = %v, %v; want 101, true", keys[0], v, ok)
+	}
+	if rhm.Size() != len(keys) || len(rhm.Keys()) != len(keys) {
+		t.Errorf("Size() = %d, len(Keys()) = %d; want %d", rhm.Size(), len(rhm.Keys()), len(keys))
+	}
+}
+
+func TestDeleteManyThenResize(t *testing.T) {
+	rhm := NewRobinHoodMap(16)
+	for i := 0; i < 1000; i++ {
+		rhm.Put(fmt.Sprintf("station-%d", i), i)
+	}
+	for i := 0; i < 1000; i += 2 {
+		rhm.Delete(fmt.Sprintf("station-%d", i))
+	}
+	for i := 1000; i < 2000; i++ {
+		rhm.Put(fmt.Sprintf("station-%d", i), i)
+	}
+
+	for i := 0; i < 2000; i++ {
+		v, ok := rhm.Get(fmt.Sprintf("station-%d", i))
+		if wantOK := i >= 1000 || i%2 == 1; ok != wantOK || (ok && v != i) {
+			t.Errorf("Get(station-%d) = %v, %v; want present=%v", i, v, ok, wantOK)
+		}
+	}
+	if rhm.Size() != 1500 {
+		t.Errorf("Size() = %d, want 1500", rhm.Size())
+	}
+}
+
+func TestDeleteShrinks(t *testing.T) {
+	rhm := NewRobinHoodMap(16)
+	for i := 0; i < 1000; i++ {
+		rhm.Put(fmt.Sprintf("station-%d", i), i)
+	}
+	grown := rhm.size
+
+	for i := 0; i < 990; i++ {
+		rhm.Delete(fmt.Sprintf("station-%d", i))
+	}
+
+	if rhm.size >= grown {
+		t.Errorf("size = %d after deletes, want less than %d", rhm.size, grown)
+	}
+	if rhm.size < minSize {
+		t.Errorf("size = %d, want at least %d", rhm.size, minSize)
+	}
+	for i := 990; i < 1000; i++ {
+		if v, ok := rhm.Get(fmt.Sprintf("station-%d", i)); !ok || v != i {
+			t.Errorf("Get(station-%d) = %v, %v; want %d, true", i, v, ok, i)
+		}
+	}
+
+	for i := 990; i < 1000; i++ {
+		rhm.Delete(fmt.Sprintf("station-%d", i))
+	}
+	if rhm.size != minSize || rhm.Size() != 0 {
+		t.Errorf("size = %d, Size() = %d; want %d, 0", rhm.size, rhm.Size(), minSize)
+	}
+}
+
+func TestSetMinLoadFactorZeroDisablesShrinking(t *testing.T) {
+	rhm := NewRobinHoodMap(16)
+	rhm.SetMinLoadFactor(0)
+	for i := 0; i < 100; i++ {
+		rhm.Put(fmt.Sprintf("station-%d", i), i)
+	}
+	grown := rhm.size
+	for i := 0; i < 100; i++ {
+		rhm.Delete(fmt.Sprintf("station-%d", i))
+	}
+	if rhm.size != grown {
+		t.Errorf("size = %d, want unchanged %d", rhm.size, grown)
+	}
+}
+
+func TestShrinkToFit(t *testing.T) {
+	// sized for far more stations than arrive, with some deleted again
+	rhm := NewRobinHoodMap(1 << 14)
+	rhm.SetMinLoadFactor(0)
+	for i := 0; i < 500; i++ {
+		rhm.Put(fmt.Sprintf("station-%d", i), i)
+	}
+	for i := 0; i < 500; i += 5 {
+		rhm.Delete(fmt.Sprintf("station-%d", i))
+	}
+	before := rhm.size
+
+	rhm.ShrinkToFit()
+
+	// 400 entries need 1024 slots at the default max load factor of 0.75
+	if rhm.size != 1024 || rhm.size >= before {
+		t.Errorf("size = %d after ShrinkToFit, want 1024", rhm.size)
+	}
+	if rhm.tombstones != 0 || rhm.Size() != 400 {
+		t.Errorf("tombstones = %d, Size() = %d; want 0, 400", rhm.tombstones, rhm.Size())
+	}
+	for i := 0; i < 500; i++ {
+		v, ok := rhm.Get(fmt.Sprintf("station-%d", i))
+		if deleted := i%5 == 0; ok == deleted || (ok && v != i) {
+			t.Errorf("Get(station-%d) = %v, %v after ShrinkToFit", i, v, ok)
+		}
+	}
+
+	// a fitted map stays put
+	rhm.ShrinkToFit()
+	if rhm.size != 1024 {
+		t.Errorf("size = %d after a second ShrinkToFit, want 1024", rhm.size)
+	}
+
+	empty := NewRobinHoodMap(1 << 10)
+	empty.ShrinkToFit()
+	if empty.size != minSize {
+		t.Errorf("empty map size = %d after ShrinkToFit, want %d", empty.size, minSize)
+	}
+}
+
+func TestPutAll(t *testing.T) {
+	rhm := NewRobinHoodMap(16)
+	rhm.Put("existing", 0)
+
+	pairs := []RHKeyValuePair{
+		{Key: "a", Value: 1},
+		{Key: "b", Value: 2},
+		{Key: "a", Value: 3},
+		{Key: "existing", Value: 4},
+	}
+	for i := 0; i < 100; i++ {
+		pairs = append(pairs, RHKeyValuePair{Key: fmt.Sprintf("station-%d", i), Value: i})
+	}
+	rhm.PutAll(pairs)
+
+	want := map[string]interface{}{"a": 3, "b": 2, "existing": 4}
+	for k, v := range want {
+		if got, ok := rhm.Get(k); !ok || got != v {
+			t.Errorf("Get(%q) = %v, %v; want %v, true", k, got, ok, v)
+		}
+	}
+	if rhm.Size() != 103 {
+		t.Errorf("Size() = %d, want 103", rhm.Size())
+	}
+}
+
+func TestSortedKeys(t *testing.T) {
+	rhm := NewRobinHoodMap(16)
+	keys := []string{"Zürich", "Abha", "Åbo", "Berlin", "abha", "Ürümqi", "Cairo"}
+	for i, key := range keys {
+		rhm.Put(key, i)
+	}
+
+	// Byte order: ASCII upper case, then lower case, then multi-byte runes
+	want := []string{"Abha", "Berlin", "Cairo", "Zürich", "abha", "Åbo", "Ürümqi"}
+
+	if got := rhm.SortedKeys(); !slices.Equal(got, want) {
+		t.Errorf("SortedKeys() = %q, want %q", got, want)
+	}
+
+	entries := rhm.EntriesSorted()
+	if len(entries) != len(want) {
+		t.Fatalf("len(EntriesSorted()) = %d, want %d", len(entries), len(want))
+	}
+	for i, entry := range entries {
+		if entry.Key != want[i] {
+			t.Errorf("EntriesSorted()[%d].Key = %q, want %q", i, entry.Key, want[i])
+		}
+		if entry.Value != slices.Index(keys, entry.Key) {
+			t.Errorf("EntriesSorted()[%d].Value = %v, want %d", i, entry.Value, slices.Index(keys, entry.Key))
+		}
+	}
+}
+
+func TestGetOrInsert(t *testing.T) {
+	rhm := NewRobinHoodMap(16)
+	calls := 0
+	create := func() interface{} {
+		calls++
+		return calls
+	}
+
+	for round := 0; round < 3; round++ {
+		for i := 0; i < 100; i++ {
+			value, existed := rhm.GetOrInsert(fmt.Sprintf("station-%d", i), create)
+			if existed != (round > 0) {
+				t.Fatalf("round %d: GetOrInsert(station-%d) existed = %v", round, i, existed)
+			}
+			if value != i+1 {
+				t.Fatalf("round %d: GetOrInsert(station-%d) = %v, want %d", round, i, value, i+1)
+			}
+		}
+	}
+
+	if calls != 100 {
+		t.Errorf("create called %d times, want 100", calls)
+	}
+	if rhm.Size() != 100 {
+		t.Errorf("Size() = %d, want 100", rhm.Size())
+	}
+	for i := 0; i < 100; i++ {
+		if v, ok := rhm.Get(fmt.Sprintf("station-%d", i)); !ok || v != i+1 {
+			t.Errorf("Get(station-%d) = %v, %v; want %d, true", i, v, ok, i+1)
+		}
+	}
+}
+
+func TestUpsert(t *testing.T) {
+	type stats struct{ min, max, sum, count int64 }
+	accumulate := func(s stats, existed bool, temperature int64) stats {
+		if !existed {
+			return stats{min: temperature, max: temperature, sum: temperature, count: 1}
+		}
+		return stats{min: min(s.min, temperature), max: max(s.max, temperature), sum: s.sum + temperature, count: s.count + 1}
+	}
+
+	// starts small so the upserts resize the map
+	rhm := NewRobinHoodMap(16)
+	reference := make(map[string]stats)
+	for i := 0; i < 10000; i++ {
+		station := fmt.Sprintf("station-%d", i*7919%413)
+		temperature := int64(i*31%1999 - 999)
+
+		rhm.Upsert(station, func(old interface{}, existed bool) interface{} {
+			if existed != (old != nil) {
+				t.Fatalf("Upsert(%s) passed %v, %v", station, old, existed)
+			}
+			s, _ := old.(stats)
+			return accumulate(s, existed, temperature)
+		})
+		s, existed := reference[station]
+		reference[station] = accumulate(s, existed, temperature)
+	}
+
+	if rhm.Size() != len(reference) {
+		t.Errorf("Size() = %d, want %d", rhm.Size(), len(reference))
+	}
+	for station, want := range reference {
+		if got, ok := rhm.Get(station); !ok || got != want {
+			t.Errorf("Get(%s) = %v, %v; want %v", station, got, ok, want)
+		}
+	}
+}
+
+func TestContains(t *testing.T) {
+	rhm := NewRobinHoodMap(64)
+
+	// one long chain, plus an absent key that has to walk all of it
+	keys := keysWithIdealSlot(rhm, 3, 11)
+	chain, absent := keys[:10], keys[10]
+	for i, key := range chain {
+		rhm.Put(key, i)
+	}
+	rhm.Put("other", nil)
+
+	for _, key := range append(chain, "other") {
+		if !rhm.Contains(key) {
+			t.Errorf("Contains(%q) = false, want true", key)
+		}
+	}
+	for _, key := range []string{absent, "missing", ""} {
+		if rhm.Contains(key) {
+			t.Errorf("Contains(%q) = true, want false", key)
+		}
+	}
+
+	rhm.Delete(chain[0])
+	if rhm.Contains(chain[0]) {
+		t.Errorf("Contains(%q) = true after Delete", chain[0])
+	}
+	if !rhm.Contains(chain[9]) {
+		t.Errorf("Contains(%q) = false after deleting the chain head", chain[9])
+	}
+
+	if rhm.Len() != 10 || rhm.Len() != rhm.Size() {
+		t.Errorf("Len() = %d, Size() = %d; want 10", rhm.Len(), rhm.Size())
+	}
+}
+
+func TestSetMinLoadFactor(t *testing.T) {
+	for _, f := range []float64{-0.1, 0.375, 0.6, 1} {
+		if err := NewRobinHoodMap(16).SetMinLoadFactor(f); err == nil {
+			t.Errorf("SetMinLoadFactor(%v) accepted with a max load factor of 0.75", f)
+		}
+	}
+
+	// the highest accepted factor still leaves the halved table room
+	rhm := NewRobinHoodMap(16)
+	if err := rhm.SetMinLoadFactor(0.37); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 200; i++ {
+		rhm.Put(fmt.Sprintf("station-%d", i), i)
+	}
+	for i := 0; i < 190; i++ {
+		rhm.Delete(fmt.Sprintf("station-%d", i))
+	}
+	for i := 190; i < 200; i++ {
+		if v, ok := rhm.Get(fmt.Sprintf("station-%d", i)); !ok || v != i {
+			t.Errorf("Get(station-%d) = %v, %v", i, v, ok)
+		}
+	}
+	if load := rhm.LoadFactor(); load > 0.75 {
+		t.Errorf("load factor %.3f after shrinking", load)
+	}
+}
+
+func TestSetMaxLoadFactor(t *testing.T) {
+	for _, f := range []float64{0, 1, -0.5, 1.5} {
+		if err := NewRobinHoodMap(16).SetMaxLoadFactor(f); err == nil {
+			t.Errorf("SetMaxLoadFactor(%v) accepted", f)
+		}
+	}
+
+	dense, sparse := NewRobinHoodMap(16), NewRobinHoodMap(16)
+	// the default min load factor of 0.15 leaves no room below 0.25
+	if err := sparse.SetMaxLoadFactor(0.25); err == nil {
+		t.Error("SetMaxLoadFactor(0.25) accepted with a min load factor of 0.15")
+	}
+	if err := sparse.SetMinLoadFactor(0.1); err != nil {
+		t.Fatal(err)
+	}
+	if err := sparse.SetMaxLoadFactor(0.25); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 10; i++ {
+		key := fmt.Sprintf("station-%d", i)
+		dense.Put(key, i)
+		sparse.Put(key, i)
+	}
+
+	_, denseSize, denseLoad, _ := dense.Stats()
+	_, sparseSize, sparseLoad, _ := sparse.Stats()
+	if denseSize != 16 {
+		t.Errorf("default map grew to %d slots for 10 keys", denseSize)
+	}
+	if sparseSize != 64 || sparseLoad > 0.25 {
+		t.Errorf("low load factor map: size %d, load %.3f; want 64, <= 0.25", sparseSize, sparseLoad)
+	}
+	if sparseLoad >= denseLoad {
+		t.Errorf("low load factor map is not sparser: %.3f >= %.3f", sparseLoad, denseLoad)
+	}
+
+	// lowering the factor on a full map grows it right away
+	if err := dense.SetMaxLoadFactor(0.5); err != nil {
+		t.Fatal(err)
+	}
+	if _, size, load, _ := dense.Stats(); size != 32 || load > 0.5 {
+		t.Errorf("after SetMaxLoadFactor(0.5): size %d, load %.3f", size, load)
+	}
+	for i := 0; i < 10; i++ {
+		if v, ok := dense.Get(fmt.Sprintf("station-%d", i)); !ok || v != i {
+			t.Errorf("Get(station-%d) = %v, %v", i, v, ok)
+		}
+	}
+}
+
+func TestFromGoMap(t *testing.T) {
+	m := map[string]interface{}{"": "empty"}
+	for i := 0; i < 500; i++ {
+		m[fmt.Sprintf("station-%d", i)] = i
+	}
+
+	rhm := FromGoMap(m)
+	if rhm.Size() != len(m) {
+		t.Fatalf("Size() = %d, want %d", rhm.Size(), len(m))
+	}
+
+	got := make(map[string]interface{}, len(m))
+	for _, entry := range rhm.Entries() {
+		if _, dup := got[entry.Key]; dup {
+			t.Errorf("Entries() yields %q twice", entry.Key)
+		}
+		got[entry.Key] = entry.Value
+	}
+	if !maps.Equal(got, m) {
+		t.Errorf("round trip through FromGoMap and Entries() lost pairs")
+	}
+
+	if empty := FromGoMap(nil); empty.Size() != 0 || len(empty.Entries()) != 0 {
+		t.Errorf("FromGoMap(nil) has %d entries", empty.Size())
+	}
+}
+
+func TestProbeHistogram(t *testing.T) {
+	rhm := NewRobinHoodMap(16)
+	if got := rhm.ProbeHistogram(); len(got) != 0 {
+		t.Errorf("empty map histogram = %v", got)
+	}
+
+	// three keys wanting slot 3 end up at distances 0, 1 and 2, and a key
+	// for slot 4 gets pushed past them to distance 2
+	for _, key := range keysWithIdealSlot(rhm, 3, 3) {
+		rhm.Put(key, nil)
+	}
+	rhm.Put(keysWithIdealSlot(rhm, 4, 1)[0], nil)
+	if got, want := rhm.ProbeHistogram(), []int{1, 1, 2}; !slices.Equal(got, want) {
+		t.Errorf("ProbeHistogram() = %v, want %v", got, want)
+	}
+
+	for i := 0; i < 1000; i++ {
+		rhm.Put(fmt.Sprintf("station-%d", i), i)
+	}
+	for i := 0; i < 1000; i += 3 {
+		rhm.Delete(fmt.Sprintf("station-%d", i))
+	}
+	total := 0
+	for _, n := range rhm.ProbeHistogram() {
+		total += n
+	}
+	if total != rhm.Len() {
+		t.Errorf("histogram counts %d entries, map holds %d", total, rhm.Len())
+	}
+}
+
+func TestProbing(t *testing.T) {
+	for _, probing := range []Probing{LinearProbing, TriangularProbing} {
+		t.Run(fmt.Sprint(probing), func(t *testing.T) {
+			rhm := NewRobinHoodMapWithProbing(16, probing)
+			if err := rhm.SetMaxLoadFactor(0.9); err != nil {
+				t.Fatal(err)
+			}
+
+			// a long displaced chain, then enough keys to run at high load
+			chain := keysWithIdealSlot(rhm, 5, 10)
+			for i, key := range chain {
+				rhm.Put(key, i)
+			}
+			for i := 0; i < 5000; i++ {
+				rhm.Put(fmt.Sprintf("station-%d", i), i)
+			}
+			for i := 0; i < 5000; i += 4 {
+				rhm.Delete(fmt.Sprintf("station-%d", i))
+			}
+			for i := 0; i < 5000; i += 8 {
+				rhm.Put(fmt.Sprintf("station-%d", i), -i)
+			}
+
+			for i, key := range chain {
+				if v, ok := rhm.Get(key); !ok || v != i {
+					t.Errorf("Get(%s) = %v, %v; want %d", key, v, ok, i)
+				}
+			}
+			for i := 0; i < 5000; i++ {
+				key := fmt.Sprintf("station-%d", i)
+				v, ok := rhm.Get(key)
+				switch {
+				case i%8 == 0:
+					if !ok || v != -i {
+						t.Errorf("Get(%s) = %v, %v; want %d", key, v, ok, -i)
+					}
+				case i%4 == 0:
+					if ok {
+						t.Errorf("deleted %s still present", key)
+					}
+				case !ok || v != i:
+					t.Errorf("Get(%s) = %v, %v; want %d", key, v, ok, i)
+				}
+			}
+			if want := 10 + 5000 - 5000/8; rhm.Size() != want {
+				t.Errorf("Size() = %d, want %d", rhm.Size(), want)
+			}
+			if clone := rhm.Clone(); !clone.Contains(chain[9]) {
+				t.Error("clone lost a key of the chain")
+			}
+		})
+	}
+}
+
+func TestForEachEntry(t *testing.T) {
+	rhm := NewRobinHoodMap(16)
+	for i := 0; i < 1000; i++ {
+		rhm.Put(fmt.Sprintf("station-%d", i), i)
+	}
+	for i := 0; i < 1000; i += 5 {
+		rhm.Delete(fmt.Sprintf("station-%d", i))
+	}
+
+	seen := map[string]bool{}
+	rhm.ForEachEntry(func(e Entry) {
+		if seen[e.Key] {
+			t.Errorf("%s visited twice", e.Key)
+		}
+		seen[e.Key] = true
+
+		if e.Hash != rhm.fastHash(e.Key) {
+			t.Errorf("%s has hash %d, want %d", e.Key, e.Hash, rhm.fastHash(e.Key))
+		}
+		if e.Distance < 0 || int(e.Distance) >= rhm.size {
+			t.Errorf("%s has distance %d", e.Key, e.Distance)
+		}
+		if v, ok := rhm.Get(e.Key); !ok || v != e.Value {
+			t.Errorf("%s yields value %v, Get returns %v, %v", e.Key, e.Value, v, ok)
+		}
+	})
+	if len(seen) != rhm.Size() {
+		t.Errorf("visited %d entries, want %d", len(seen), rhm.Size())
+	}
+}
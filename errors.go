@@ -0,0 +1,73 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Errors aggregation fails with, for errors.Is. The ones carrying details
+// come as the error types below, which match these with errors.Is and can
+// be taken apart with errors.As. Files that can't be opened fail with the
+// *fs.PathError of os.Open, so errors.Is(err, fs.ErrNotExist) tells a
+// missing file.
+var (
+	// ErrMalformedLine is a line that doesn't parse, in strict mode
+	ErrMalformedLine = errors.New("malformed line")
+
+	// ErrTooManyStations is an input with more than Options.MaxStations
+	// stations
+	ErrTooManyStations = errors.New("too many stations")
+
+	// ErrLineTooLong is a line longer than Options.MaxLineLength
+	ErrLineTooLong = errors.New("line too long")
+
+	// ErrMmap is a file the mmap engine failed to map
+	ErrMmap = errors.New("mmap failed")
+
+	// ErrWorkerPanicked is a worker of the mmap engine that panicked
+	ErrWorkerPanicked = errors.New("worker panicked")
+)
+
+// MalformedLineError is the line that failed a strict run
+type MalformedLineError struct {
+	MalformedLine
+}
+
+func (e *MalformedLineError) Error() string {
+	return fmt.Sprintf("malformed line %d: %q", e.Line, e.Content)
+}
+
+func (e *MalformedLineError) Is(target error) bool {
+	return target == ErrMalformedLine
+}
+
+// TooManyStationsError is the number of stations found beyond
+// Options.MaxStations. Workers give up as soon as they see one too many, so
+// Stations is how many were found by then, not how many the input has.
+type TooManyStationsError struct {
+	Stations    int
+	MaxStations int
+}
+
+func (e *TooManyStationsError) Error() string {
+	return fmt.Sprintf("found %d stations, exceeds MaxStations=%d; increase with -max-stations", e.Stations, e.MaxStations)
+}
+
+func (e *TooManyStationsError) Is(target error) bool {
+	return target == ErrTooManyStations
+}
+
+// LineTooLongError is a line longer than Options.MaxLineLength, by the
+// byte offset it starts at
+type LineTooLongError struct {
+	Offset        int64
+	MaxLineLength int
+}
+
+func (e *LineTooLongError) Error() string {
+	return fmt.Sprintf("line at byte %d is longer than %d bytes", e.Offset, e.MaxLineLength)
+}
+
+func (e *LineTooLongError) Is(target error) bool {
+	return target == ErrLineTooLong
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestErrors(t *testing.T) {
+	missing := filepath.Join(t.TempDir(), "missing.txt")
+	for _, engine := range engines {
+		if _, err := engine.evaluate(missing, Options{}); !errors.Is(err, fs.ErrNotExist) {
+			t.Errorf("%s on a missing file: %v", engine.name, err)
+		}
+	}
+
+	malformed := writeFixture(t, "Hamburg;12.0\nHamburg;12\n")
+	for _, engine := range engines {
+		_, err := engine.evaluate(malformed, Options{Strict: true})
+		var lineErr *MalformedLineError
+		if !errors.Is(err, ErrMalformedLine) || !errors.As(err, &lineErr) || lineErr.Line != 2 {
+			t.Errorf("%s on a malformed line: %v", engine.name, err)
+		}
+	}
+
+	for _, engine := range engines {
+		_, err := engine.evaluate(writeFixture(t, fixture), Options{MaxStations: 5})
+		var stationsErr *TooManyStationsError
+		// the workers stop at the first station too many, before all 9
+		if !errors.Is(err, ErrTooManyStations) || !errors.As(err, &stationsErr) || stationsErr.Stations <= 5 || stationsErr.Stations > 9 {
+			t.Errorf("%s over MaxStations: %v", engine.name, err)
+		}
+	}
+	if _, err := AggregateFiles([]string{writeFixture(t, fixture), writeFixture(t, "Lima;1.0\n")}, Options{MaxStations: 9}); !errors.Is(err, ErrTooManyStations) {
+		t.Errorf("files over MaxStations: %v", err)
+	}
+
+	// chunks and buffers smaller than the line, so it has to grow them
+	content := "Hamburg;12.0\n" + strings.Repeat("x", 4096) + ";1.0\n"
+	_, streamErr := evaluate(writeFixture(t, content), 10, 512, Options{MaxLineLength: 1024})
+	f, err := os.Open(writeFixture(t, content))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	preadErr := preadRange(f, 0, int64(len(content)), int64(len(content)), 512, 1024, func([]byte) error { return nil })
+	for engine, err := range map[string]error{"stream": streamErr, "pread": preadErr} {
+		var lengthErr *LineTooLongError
+		if !errors.Is(err, ErrLineTooLong) || !errors.As(err, &lengthErr) || lengthErr.Offset != 13 {
+			t.Errorf("%s on a long line: %v", engine, err)
+		}
+	}
+
+	// a directory opens, but can't be mapped
+	if _, err := evaluateMmap(t.TempDir(), Options{}); !errors.Is(err, ErrMmap) {
+		t.Errorf("mmap of a directory: %v", err)
+	}
+
+	aggregatePart = func(chunk []byte, seq int, symbols map[string]uint64, result *workerResult, opts *Options) chunkReport {
+		panic("injected")
+	}
+	t.Cleanup(func() { aggregatePart = aggregateChunk })
+	if _, err := evaluateMmap(writeFixture(t, fixture), Options{}); !errors.Is(err, ErrWorkerPanicked) {
+		t.Errorf("panicking worker: %v", err)
+	}
+}
@@ -0,0 +1,72 @@
+package main
+
+import "bytes"
+
+// estimateWindow is how much of the input estimateStations samples
+const estimateWindow = 256 * 1024
+
+// estimateStations guesses how many distinct stations data holds from its
+// first estimateWindow bytes, to size the station tables up front instead
+// of growing them. Names seen only once in the sample hint at names the
+// sample missed, so it extrapolates with the Chao1 estimator,
+// D + f1²/(2·f2), and adds a quarter as a safety margin. The estimate is a
+// sizing hint, never a limit, and is capped at numberOfMaxStations.
+func estimateStations(data []byte, opts *Options) int {
+	sample := data[:min(len(data), estimateWindow)]
+	sample = sample[:bytes.LastIndexByte(sample, '\n')+1]
+	covered := len(sample) == len(data)
+
+	counts := make(map[string]int)
+	var line []byte
+	for len(sample) > 0 {
+		line, sample = cutLine(sample)
+		name, _, ok := opts.splitLine(line)
+		if !ok {
+			continue
+		}
+		counts[string(opts.stationKey(name))]++
+	}
+	if covered {
+		// the sample is all of data, there is nothing to extrapolate
+		return len(counts)
+	}
+
+	var once, twice int
+	for _, n := range counts {
+		switch n {
+		case 1:
+			once++
+		case 2:
+			twice++
+		}
+	}
+	estimate := float64(len(counts))
+	if twice > 0 {
+		estimate += float64(once) * float64(once) / float64(2*twice)
+	} else {
+		estimate += float64(once) * float64(once-1) / 2
+	}
+
+	return min(int(estimate*1.25), numberOfMaxStations)
+}
+
+// maxRowWorkers caps the workers Options.RowsPerWorker starts. Every worker
+// keeps accumulators for all stations, so a tiny target on a huge file
+// must not start a worker per few rows.
+const maxRowWorkers = 256
+
+// rowWorkers splits data into parts of about rowsPerWorker rows each, the
+// rows estimated from the average line length in the first estimateWindow
+// bytes, and returns the number of parts and of workers to run on them:
+// one per part, up to maxRowWorkers.
+func rowWorkers(data []byte, rowsPerWorker int64) (workers, parts int) {
+	sample := data[:min(len(data), estimateWindow)]
+	lines := int64(bytes.Count(sample, []byte{'\n'}))
+	if lines == 0 {
+		// a single line, or lines too long to count any in the sample
+		return 1, 1
+	}
+	rows := int64(len(data)) * lines / int64(len(sample))
+	parts = int(max((rows+rowsPerWorker-1)/rowsPerWorker, 1))
+	return min(parts, maxRowWorkers), parts
+}
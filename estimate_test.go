@@ -0,0 +1,40 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"math/rand/v2"
+	"testing"
+)
+
+func TestEstimateStations(t *testing.T) {
+	rng := rand.New(rand.NewPCG(1, 2))
+
+	for _, stations := range []int{10, 400, 5_000} {
+		var content []byte
+		for i := 0; i < 500_000; i++ {
+			content = fmt.Appendf(content, "station-%d;%d.%d\n", rng.IntN(stations), rng.IntN(199)-99, rng.IntN(10))
+		}
+
+		estimate := estimateStations(content, &Options{})
+		if estimate < stations || estimate > 2*stations {
+			t.Errorf("%d stations estimated as %d", stations, estimate)
+		}
+
+		// an estimate that falls short doesn't change the results
+		content = bytes.Repeat([]byte("station-0;1.0\n"), estimateWindow/len("station-0;1.0\n")+1)
+		for i := 1; i < stations; i++ {
+			content = fmt.Appendf(content, "station-%d;1.0\n", i)
+		}
+		if estimate := estimateStations(content, &Options{}); estimate != 1 {
+			t.Errorf("sample with one station estimated as %d", estimate)
+		}
+		agg, err := evaluateMmap(writeFixture(t, string(content)), Options{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := len(agg.entries(&Options{})); got != stations {
+			t.Errorf("aggregated %d stations, want %d", got, stations)
+		}
+	}
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"runtime"
+	"sync"
+)
+
+// aggregateFile aggregates a single file of a multi-file run. Tests replace
+// it to watch how many files are aggregated at once.
+var aggregateFile = run
+
+// AggregateFiles aggregates several files and merges their totals by
+// station name. Options.Files of them are aggregated at once, each with
+// Options.Workers workers, so many files don't start a full set of workers
+// each. Lines are numbered per file, as with AggregateReaders.
+func AggregateFiles(fileNames []string, opts Options) (map[string]Stats, error) {
+	agg, err := evaluateFiles(fileNames, "auto", 0, opts)
+	if err != nil {
+		return nil, err
+	}
+	return agg.stats(&opts), nil
+}
+
+// evaluateFiles aggregates every file with the named engine and merges the
+// results. By default the files and their workers share the CPUs: as many
+// files at once as there are default workers, and those workers split
+// between them.
+func evaluateFiles(fileNames []string, engine string, chunkSize int, opts Options) (*aggregation, error) {
+	if opts.TrackLines || opts.TrackExtremes || opts.Histogram {
+		return nil, errors.New("line tracking and histograms work on a single file only")
+	}
+	if opts.Checksum != "" {
+		return nil, errors.New("checksums work on a single file only")
+	}
+
+	budget := min(max(runtime.NumCPU()-1, 1), workerCount)
+	files := opts.Files
+	if files == 0 {
+		files = budget
+	}
+	files = max(min(files, len(fileNames)), 1)
+	if opts.Workers == 0 {
+		opts.Workers = max(budget/files, 1)
+	}
+
+	var (
+		aggs = make([]*aggregation, len(fileNames))
+		errs = make([]error, len(fileNames))
+		sem  = make(chan struct{}, files)
+		wg   sync.WaitGroup
+	)
+	for i, fileName := range fileNames {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func() {
+			defer wg.Done()
+			defer func() { <-sem }()
+			aggs[i], errs[i] = aggregateFile(fileName, engine, chunkSize, opts)
+			if errs[i] != nil {
+				errs[i] = fmt.Errorf("%s: %w", fileName, errs[i])
+				return
+			}
+			// line numbers restart with every file
+			for j := range aggs[i].report.Malformed {
+				aggs[i].report.Malformed[j].File = fileName
+			}
+		}()
+	}
+	wg.Wait()
+	if err := errors.Join(errs...); err != nil {
+		return nil, err
+	}
+
+	return mergeAggregations(aggs, &opts)
+}
+
+// mergeAggregations folds the results of several files into one, in file
+// order. Malformed lines keep their line number within their own file,
+// which their File names.
+func mergeAggregations(aggs []*aggregation, opts *Options) (*aggregation, error) {
+	merged := &aggregation{stationSymbolMap: make(map[string]uint64)}
+	for _, agg := range aggs {
+		for _, entry := range agg.entries(opts) {
+			key := string(opts.stationKey(entry.name))
+			i, ok := merged.stationSymbolMap[key]
+			if !ok {
+				merged.stationSymbolMap[key] = uint64(len(merged.results))
+				merged.stationNames = append(merged.stationNames, entry.name)
+				merged.results = append(merged.results, entry.result)
+				continue
+			}
+			merged.results[i].merge(entry.result)
+		}
+
+		merged.report.TotalLines += agg.report.TotalLines
+		merged.report.ParsedLines += agg.report.ParsedLines
+		merged.report.SkippedLines += agg.report.SkippedLines
+		merged.report.InvalidNames = append(merged.report.InvalidNames, agg.report.InvalidNames...)
+		for _, m := range agg.report.Malformed {
+			if len(merged.report.Malformed) == maxReportedLines {
+				break
+			}
+			merged.report.Malformed = append(merged.report.Malformed, m)
+		}
+	}
+
+	if opts.MaxStations > 0 && len(merged.results) > opts.MaxStations {
+		return nil, &TooManyStationsError{Stations: len(merged.results), MaxStations: opts.MaxStations}
+	}
+	sortStationNames(merged.stationNames)
+	if opts.Collate != "" {
+		if err := collateStationNames(merged.stationNames, opts.Collate); err != nil {
+			return nil, err
+		}
+	}
+	return merged, nil
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"maps"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestAggregateFiles(t *testing.T) {
+	lines := strings.SplitAfter(fixture, "\n")
+	var fileNames []string
+	for i := range 6 {
+		fileNames = append(fileNames, writeFixture(t, strings.Join(lines[i*len(lines)/6:(i+1)*len(lines)/6], "")))
+	}
+
+	// count the files in flight, and the most ever at once
+	var running, highWater atomic.Int64
+	aggregateFile = func(fileName string, engine string, chunkSize int, opts Options) (*aggregation, error) {
+		n := running.Add(1)
+		defer running.Add(-1)
+		for {
+			seen := highWater.Load()
+			if n <= seen || highWater.CompareAndSwap(seen, n) {
+				break
+			}
+		}
+		if opts.Workers != 1 {
+			t.Errorf("file aggregated with %d workers, want 1", opts.Workers)
+		}
+		time.Sleep(10 * time.Millisecond)
+		return run(fileName, engine, chunkSize, opts)
+	}
+	t.Cleanup(func() { aggregateFile = run })
+
+	got, err := AggregateFiles(fileNames, Options{Files: 2, Workers: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, err := AggregateReader(strings.NewReader(fixture), Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !maps.Equal(got, want) {
+		t.Errorf("got  %v\nwant %v", got, want)
+	}
+	if n := highWater.Load(); n != 2 {
+		t.Errorf("aggregated up to %d files at once, want 2", n)
+	}
+
+	if _, err := AggregateFiles(append(fileNames, "missing"), Options{Files: 2, Workers: 1}); err == nil || !strings.Contains(err.Error(), "missing") {
+		t.Errorf("err = %v, want the missing file named", err)
+	}
+
+	// malformed lines are reported with the file they are in
+	broken := writeFixture(t, "Hamburg;12.0\nHamburg;x\n")
+	agg, err := evaluateFiles([]string{fileNames[0], broken}, "auto", 0, Options{Workers: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []MalformedLine{{Line: 2, Content: "Hamburg;x", File: broken}}; !slices.Equal(agg.report.Malformed, want) {
+		t.Errorf("malformed: got %v, want %v", agg.report.Malformed, want)
+	}
+}
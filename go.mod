@@ -1,3 +1,5 @@
 module github.com/zhehlovvalentyn/1brc
 
 go 1.24.1
+
+require golang.org/x/text v0.30.0
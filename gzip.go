@@ -0,0 +1,194 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"runtime"
+)
+
+// bgzfHeaderSize is the length of a BGZF block header up to and including
+// the block size
+const bgzfHeaderSize = 18
+
+// isGzipFile reports whether fileName is a regular file starting with the
+// gzip magic. Other files aren't read, so pipes keep their first bytes.
+func isGzipFile(fileName string) (bool, error) {
+	stat, err := os.Stat(fileName)
+	if err != nil || !stat.Mode().IsRegular() {
+		return false, err
+	}
+	f, err := os.Open(fileName)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+
+	var magic [2]byte
+	if _, err := io.ReadFull(f, magic[:]); err != nil {
+		return false, nil
+	}
+	return magic == [2]byte{0x1f, 0x8b}, nil
+}
+
+// evaluateGzip is the streaming engine on a gzip compressed file. BGZF
+// files, concatenated gzip members that carry their compressed size like
+// bgzip writes them, are decompressed by several goroutines at once. Any
+// other gzip file is decompressed serially. Up to chanSize decompressed
+// chunks are queued, as with evaluate.
+func evaluateGzip(fileName string, chanSize int, chunkSize int, opts Options) (*aggregation, error) {
+	if chunkSize == 0 {
+		// no telling the decompressed size up front
+		chunkSize = defaultChunkSize
+	}
+	f, err := os.Open(fileName)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	// the checksum is of the compressed file, as it is on disk
+	in, err := snapshot(f)
+	if err != nil {
+		return nil, err
+	}
+	var h hash.Hash
+	if opts.Checksum != "" {
+		h = newChecksum(opts.Checksum)
+		in = io.TeeReader(in, h)
+	}
+	br := bufio.NewReaderSize(in, 64*1024)
+	var r io.Reader
+	if header, _ := br.Peek(bgzfHeaderSize); bgzfBlockSize(header) > 0 {
+		workers := opts.Workers
+		if workers == 0 {
+			workers = min(max(runtime.NumCPU()-1, 1), workerCount)
+		}
+		opts.logger().Info("decompressing BGZF blocks in parallel", "file", fileName, "workers", workers)
+		pr := newBGZFReader(br, workers)
+		defer pr.Close()
+		r = pr
+	} else {
+		zr, err := gzip.NewReader(br)
+		if err != nil {
+			return nil, err
+		}
+		r = zr
+	}
+	agg, err := evaluateReader(truncationReader{r}, chanSize, chunkSize, opts)
+	if err != nil || h == nil {
+		return agg, err
+	}
+	agg.checksum = h.Sum(nil)
+	return agg, nil
+}
+
+// errTruncatedGzip is the error of a gzip file that ends mid-stream
+var errTruncatedGzip = errors.New("gzip file is truncated")
+
+// truncationReader reports the io.ErrUnexpectedEOF of a truncated gzip file
+// as errTruncatedGzip, which evaluateReader doesn't take for the end of
+// the input
+type truncationReader struct {
+	r io.Reader
+}
+
+func (t truncationReader) Read(p []byte) (int, error) {
+	n, err := t.r.Read(p)
+	if errors.Is(err, io.ErrUnexpectedEOF) {
+		err = errTruncatedGzip
+	}
+	return n, err
+}
+
+// bgzfBlockSize returns the size of the BGZF block starting with header,
+// or 0 if header doesn't start one: a gzip member header with an extra
+// field whose first subfield is BC, holding the block size minus one
+func bgzfBlockSize(header []byte) int {
+	if len(header) < bgzfHeaderSize || header[0] != 0x1f || header[1] != 0x8b || header[2] != 8 || header[3]&4 == 0 {
+		return 0
+	}
+	if header[12] != 'B' || header[13] != 'C' || binary.LittleEndian.Uint16(header[14:]) != 2 {
+		return 0
+	}
+	return int(binary.LittleEndian.Uint16(header[16:])) + 1
+}
+
+// newBGZFReader decompresses the BGZF blocks read from r with up to workers
+// goroutines, and returns the decompressed data in order. Closing the
+// reader stops the decompression.
+func newBGZFReader(r io.Reader, workers int) io.ReadCloser {
+	pr, pw := io.Pipe()
+
+	// blocks in file order, each delivering its decompressed data once done
+	type result struct {
+		data []byte
+		err  error
+	}
+	pending := make(chan chan result, workers)
+	slots := make(chan struct{}, workers)
+
+	go func() {
+		defer close(pending)
+		header := make([]byte, bgzfHeaderSize)
+		for {
+			if _, err := io.ReadFull(r, header); err == io.EOF {
+				return
+			} else if err != nil {
+				pw.CloseWithError(err)
+				return
+			}
+			size := bgzfBlockSize(header)
+			if size < bgzfHeaderSize {
+				pw.CloseWithError(fmt.Errorf("not a BGZF block header: % x", header))
+				return
+			}
+			block := make([]byte, size)
+			copy(block, header)
+			if _, err := io.ReadFull(r, block[bgzfHeaderSize:]); err != nil {
+				pw.CloseWithError(fmt.Errorf("truncated BGZF block: %w", err))
+				return
+			}
+
+			done := make(chan result, 1)
+			slots <- struct{}{}
+			go func() {
+				defer func() { <-slots }()
+				zr, err := gzip.NewReader(bytes.NewReader(block))
+				if err != nil {
+					done <- result{err: err}
+					return
+				}
+				data, err := io.ReadAll(zr)
+				done <- result{data: data, err: err}
+			}()
+			pending <- done
+		}
+	}()
+
+	go func() {
+		for done := range pending {
+			res := <-done
+			if res.err == nil {
+				_, res.err = pw.Write(res.data)
+			}
+			if res.err != nil {
+				pw.CloseWithError(res.err)
+				// let the block reader run out
+				for done := range pending {
+					<-done
+				}
+				return
+			}
+		}
+		pw.Close()
+	}()
+
+	return pr
+}
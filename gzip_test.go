@@ -0,0 +1,120 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"errors"
+	"strings"
+	"testing"
+)
+
+// gzipMembers compresses every part into a gzip member of its own, with
+// the BGZF block size in the header if bgzf is set
+func gzipMembers(t *testing.T, parts []string, bgzf bool) string {
+	var out bytes.Buffer
+	for _, part := range parts {
+		var member bytes.Buffer
+		zw := gzip.NewWriter(&member)
+		if bgzf {
+			zw.Header.Extra = []byte{'B', 'C', 2, 0, 0, 0}
+		}
+		if _, err := zw.Write([]byte(part)); err != nil {
+			t.Fatal(err)
+		}
+		if err := zw.Close(); err != nil {
+			t.Fatal(err)
+		}
+		block := member.Bytes()
+		if bgzf {
+			binary.LittleEndian.PutUint16(block[16:], uint16(len(block)-1))
+		}
+		out.Write(block)
+	}
+	return out.String()
+}
+
+func TestGzip(t *testing.T) {
+	content := strings.Repeat(fixture, 50)
+	want, err := evaluateMmap(writeFixture(t, content), Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// members cut lines in half, as bgzip does
+	var parts []string
+	for rest := content; len(rest) > 0; {
+		n := min(len(rest), 97)
+		parts = append(parts, rest[:n])
+		rest = rest[n:]
+	}
+
+	for _, bgzf := range []bool{false, true} {
+		fileName := writeFixture(t, gzipMembers(t, parts, bgzf))
+		if got, err := isGzipFile(fileName); err != nil || !got {
+			t.Fatalf("isGzipFile = %t, %v", got, err)
+		}
+		if header := []byte(gzipMembers(t, parts[:1], bgzf)); (bgzfBlockSize(header) > 0) != bgzf {
+			t.Errorf("bgzf %t: block size %d", bgzf, bgzfBlockSize(header))
+		}
+
+		for _, opts := range []Options{{}, {Workers: 4}} {
+			agg, err := run(fileName, "auto", 1024, opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got, want := string(appendResults(nil, agg, &opts)), string(appendResults(nil, want, &opts)); got != want {
+				t.Errorf("bgzf %t, %d workers: got  %s\nwant %s", bgzf, opts.Workers, got, want)
+			}
+			if agg.report.TotalLines != want.report.TotalLines {
+				t.Errorf("bgzf %t: %d lines, want %d", bgzf, agg.report.TotalLines, want.report.TotalLines)
+			}
+		}
+	}
+
+	// a truncated file fails instead of dropping the rest
+	for _, bgzf := range []bool{false, true} {
+		data := gzipMembers(t, parts, bgzf)
+		if _, err := run(writeFixture(t, data[:len(data)-10]), "auto", 1024, Options{}); !errors.Is(err, errTruncatedGzip) {
+			t.Errorf("bgzf %t: truncated file gave %v", bgzf, err)
+		}
+	}
+}
+
+func TestGzipWithEveryEngine(t *testing.T) {
+	fileName := writeFixture(t, gzipMembers(t, []string{fixture}, false))
+
+	for _, engine := range []string{"mmap", "stream", "pread"} {
+		agg, err := run(fileName, engine, 1024, Options{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := string(appendResults(nil, agg, &Options{})); got != fixtureResult {
+			t.Errorf("-engine %s: got  %s\nwant %s", engine, got, fixtureResult)
+		}
+	}
+
+	agg, err := evaluateLimited(fileName, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := string(appendResults(nil, agg, &Options{})); got != fixtureResult {
+		t.Errorf("-limit-memory: got  %s\nwant %s", got, fixtureResult)
+	}
+
+	report, err := validateFile(fileName, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.TotalLines != 12 || report.SkippedLines != 0 {
+		t.Errorf("validateFile: report = %+v, want 12 clean lines", report)
+	}
+
+	// neither can work on the decompressed lines
+	if _, err := run(fileName, "auto", 1024, Options{Tail: 3}); err == nil {
+		t.Error("-tail on a gzip file: no error")
+	}
+	if _, err := compareEngines(fileName, 1024, Options{}); err == nil {
+		t.Error("-compare-engines on a gzip file: no error")
+	}
+}
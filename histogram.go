@@ -0,0 +1,136 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"maps"
+	"slices"
+	"strconv"
+)
+
+// histogram counts the readings of a station per bucket, keyed by the
+// lowest temperature of the bucket in tenths of a degree. Temperatures are
+// discrete, so with the default bucket of a tenth the counts are exact.
+// Only buckets that occur are stored, so the memory taken depends on the
+// readings, not on the bucket width or the temperature range.
+type histogram map[int64]int64
+
+// percentiles printed along with every histogram
+var histogramPercentiles = []int{50, 90, 99}
+
+// bucketOf returns the bucket a temperature falls into, with buckets of
+// width tenths of a degree. Zero is a bucket boundary.
+func bucketOf(temperature, width int64) int64 {
+	if width <= 1 {
+		return temperature
+	}
+	bucket := temperature / width
+	if temperature%width < 0 {
+		bucket--
+	}
+	return bucket * width
+}
+
+// percentile returns the bucket holding the p-th percentile of the
+// readings, by the nearest rank method
+func (h histogram) percentile(p int) int64 {
+	var total int64
+	for _, count := range h {
+		total += count
+	}
+	rank := max((total*int64(p)+99)/100, 1)
+
+	buckets := slices.Sorted(maps.Keys(h))
+	for _, bucket := range buckets {
+		if rank -= h[bucket]; rank <= 0 {
+			return bucket
+		}
+	}
+	return buckets[len(buckets)-1]
+}
+
+// addToHistogram counts a reading in the histogram of its station
+func (r *workerResult) addToHistogram(key []byte, temperature, width int64) {
+	if r.histograms == nil {
+		r.histograms = make(map[string]histogram)
+	}
+
+	h, ok := r.histograms[string(key)]
+	if !ok {
+		h = make(histogram)
+		r.histograms[string(key)] = h
+	}
+	h[bucketOf(temperature, width)]++
+}
+
+// mergeHistograms folds the histograms of all workers together
+func mergeHistograms(workerResults WorkerResults) map[string]histogram {
+	merged := make(map[string]histogram)
+	for w := range workerResults {
+		for key, h := range workerResults[w].histograms {
+			total, ok := merged[key]
+			if !ok {
+				merged[key] = h
+				continue
+			}
+			for temperature, count := range h {
+				total[temperature] += count
+			}
+		}
+	}
+	return merged
+}
+
+// writeHistograms writes the histogram of every station as a JSON array,
+// listing only the buckets that occur, in ascending order, and the buckets
+// of some percentiles:
+//
+//	{"station":"Hamburg","histogram":{"-5.3":1,"12.0":2,"34.2":1},"p50":12.0,"p90":34.2,"p99":34.2}
+func writeHistograms(w io.Writer, agg *aggregation, opts *Options) error {
+	bw := bufio.NewWriterSize(w, 64*1024)
+	buf := make([]byte, 0, 256)
+
+	_ = bw.WriteByte('[')
+	for i, entry := range agg.entries(opts) {
+		buf = buf[:0]
+		if i != 0 {
+			buf = append(buf, ',')
+		}
+		buf = append(buf, '\n')
+
+		name, err := json.Marshal(string(entry.name))
+		if err != nil {
+			return err
+		}
+		buf = append(buf, `{"station":`...)
+		buf = append(buf, name...)
+		buf = append(buf, `,"histogram":{`...)
+
+		h := agg.histograms[string(opts.stationKey(entry.name))]
+		for j, temperature := range slices.Sorted(maps.Keys(h)) {
+			if j != 0 {
+				buf = append(buf, ',')
+			}
+			buf = append(buf, '"')
+			buf = appendTenths(buf, temperature)
+			buf = append(buf, `":`...)
+			buf = strconv.AppendInt(buf, h[temperature], 10)
+		}
+		buf = append(buf, '}')
+		for _, p := range histogramPercentiles {
+			buf = append(buf, `,"p`...)
+			buf = strconv.AppendInt(buf, int64(p), 10)
+			buf = append(buf, `":`...)
+			buf = appendTenths(buf, h.percentile(p))
+		}
+		buf = append(buf, '}')
+
+		if _, err := bw.Write(buf); err != nil {
+			return err
+		}
+	}
+	_, _ = bw.WriteString("\n]\n")
+
+	return bw.Flush()
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"maps"
+	"math/rand/v2"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestHistogram(t *testing.T) {
+	readings := []string{"12.0", "-5.3", "12.0", "34.2", "-5.3", "12.0", "0.0"}
+	var content strings.Builder
+	tally := map[string]int64{}
+	for _, reading := range readings {
+		content.WriteString("Hamburg;" + reading + "\nCracow;1.0\n")
+		tally[reading]++
+	}
+	fileName := writeFixture(t, content.String())
+	opts := Options{Histogram: true}
+
+	for _, engine := range engines {
+		t.Run(engine.name, func(t *testing.T) {
+			agg, err := engine.evaluate(fileName, opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var out bytes.Buffer
+			if err := writeResults(&out, agg, &opts); err != nil {
+				t.Fatal(err)
+			}
+			var stations []struct {
+				Station   string
+				Histogram map[string]int64
+			}
+			if err := json.Unmarshal(out.Bytes(), &stations); err != nil {
+				t.Fatalf("invalid json %s: %v", out.String(), err)
+			}
+
+			if len(stations) != 2 || stations[0].Station != "Cracow" || stations[1].Station != "Hamburg" {
+				t.Fatalf("got stations %+v", stations)
+			}
+			if got := stations[0].Histogram; !maps.Equal(got, map[string]int64{"1.0": 7}) {
+				t.Errorf("Cracow histogram %v", got)
+			}
+			if got := stations[1].Histogram; !maps.Equal(got, tally) {
+				t.Errorf("Hamburg histogram %v, want %v", got, tally)
+			}
+			if !strings.Contains(out.String(), `{"-5.3":2,"0.0":1,"12.0":3,"34.2":1}`) {
+				t.Errorf("buckets not in ascending order: %s", out.String())
+			}
+		})
+	}
+}
+
+func TestHistogramBucket(t *testing.T) {
+	rng := rand.New(rand.NewPCG(1, 2))
+	var content strings.Builder
+	var readings []int64
+	for range 1000 {
+		reading := rng.Int64N(1999) - 999
+		readings = append(readings, reading)
+		content.WriteString("Lima;" + string(appendTenths(nil, reading)) + "\n")
+	}
+	fileName := writeFixture(t, content.String())
+	opts := Options{Histogram: true, HistogramBucket: 25}
+
+	agg, err := evaluateMmap(fileName, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := agg.histograms["Lima"]
+	for bucket := range h {
+		if bucket%25 != 0 {
+			t.Fatalf("bucket %d is not a multiple of the width", bucket)
+		}
+	}
+
+	// brute force: the nearest rank reading, rounded down to its bucket
+	slices.Sort(readings)
+	for _, p := range []int{1, 25, 50, 90, 99, 100} {
+		rank := (len(readings)*p + 99) / 100
+		want := readings[rank-1] - ((readings[rank-1]%25)+25)%25
+		if got := h.percentile(p); got != want {
+			t.Errorf("p%d = %d, want %d", p, got, want)
+		}
+	}
+
+	var out bytes.Buffer
+	if err := writeResults(&out, agg, &opts); err != nil {
+		t.Fatal(err)
+	}
+	var stations []struct {
+		Histogram map[string]int64
+		P50       float64
+	}
+	if err := json.Unmarshal(out.Bytes(), &stations); err != nil {
+		t.Fatalf("invalid json %s: %v", out.String(), err)
+	}
+	if got, want := stations[0].P50, float64(h.percentile(50))/10; got != want {
+		t.Errorf("printed p50 %v, want %v", got, want)
+	}
+}
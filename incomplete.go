@@ -0,0 +1,54 @@
+package main
+
+import (
+	"bytes"
+	"io"
+)
+
+// dropIncomplete returns data without its last line if that line has no
+// newline and doesn't parse, with Options.DropIncomplete: what a writer
+// killed mid-line leaves behind. A last line that parses is a record like
+// any other, newline or not, and Strict keeps every line to fail on it.
+func dropIncomplete(data []byte, opts *Options) []byte {
+	if !opts.DropIncomplete || opts.Strict || len(data) == 0 || data[len(data)-1] == '\n' {
+		return data
+	}
+	start := bytes.LastIndexByte(data, '\n') + 1
+	if name, value, ok := opts.splitLine(data[start:]); ok {
+		if _, ok := opts.parseTemperature(value); ok {
+			return data
+		}
+		opts.logger().Info("dropping an incomplete last line", "station", string(name))
+	} else {
+		opts.logger().Info("dropping an incomplete last line")
+	}
+	return data[:start]
+}
+
+// incompleteTailWindow is how much sizeWithoutIncomplete reads at a time,
+// backwards from the end of the file, to find the start of its last line
+const incompleteTailWindow = 4096
+
+// sizeWithoutIncomplete returns size less the incomplete last line that
+// dropIncomplete would drop, for the engines that read a file by offset
+func sizeWithoutIncomplete(r io.ReaderAt, size int64, opts *Options) (int64, error) {
+	if !opts.DropIncomplete || opts.Strict || size == 0 {
+		return size, nil
+	}
+
+	var tail []byte
+	for off := size; off > 0; {
+		n := min(off, incompleteTailWindow)
+		off -= n
+		window := make([]byte, n)
+		if _, err := r.ReadAt(window, off); err != nil {
+			return 0, err
+		}
+		tail = append(window, tail...)
+		// the last line starts after the last newline before the end
+		if bytes.IndexByte(tail[:len(tail)-1], '\n') >= 0 {
+			break
+		}
+	}
+	return size - int64(len(tail)-len(dropIncomplete(tail, opts))), nil
+}
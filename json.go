@@ -0,0 +1,98 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"strconv"
+)
+
+// writeJSON writes entries as a JSON array with one object per station:
+//
+//	{"station":"Hamburg","min":-5.3,"mean":13.6,"max":34.2,"count":3}
+//
+// With Options.Exact the objects carry the sum of all readings too, so
+// partial results can be merged exactly, with Options.TrackLines
+// first_line and last_line, and with Options.TrackExtremes min_line and
+// max_line.
+func writeJSON(w io.Writer, entries []stationResult, opts *Options) error {
+	bw := bufio.NewWriterSize(w, 64*1024)
+	buf := make([]byte, 0, 256)
+
+	_ = bw.WriteByte('[')
+	for i, entry := range entries {
+		buf = buf[:0]
+		if i != 0 {
+			buf = append(buf, ',')
+		}
+		buf = append(buf, '\n')
+
+		var err error
+		if buf, err = appendJSONEntry(buf, entry, opts); err != nil {
+			return err
+		}
+		if _, err := bw.Write(buf); err != nil {
+			return err
+		}
+	}
+	_, _ = bw.WriteString("\n]\n")
+
+	return bw.Flush()
+}
+
+// writeJSONLines writes entries as JSON lines, one object per station and
+// line like in writeJSON, so every line can be parsed on its own while the
+// rest is still being written
+func writeJSONLines(w io.Writer, entries []stationResult, opts *Options) error {
+	bw := bufio.NewWriterSize(w, 64*1024)
+	buf := make([]byte, 0, 256)
+
+	for _, entry := range entries {
+		var err error
+		if buf, err = appendJSONEntry(buf[:0], entry, opts); err != nil {
+			return err
+		}
+		buf = append(buf, '\n')
+		if _, err := bw.Write(buf); err != nil {
+			return err
+		}
+	}
+
+	return bw.Flush()
+}
+
+// appendJSONEntry appends the JSON object of a single station
+func appendJSONEntry(buf []byte, entry stationResult, opts *Options) ([]byte, error) {
+	name, err := json.Marshal(string(entry.name))
+	if err != nil {
+		return nil, err
+	}
+	buf = append(buf, `{"station":`...)
+	buf = append(buf, name...)
+	buf = append(buf, `,"min":`...)
+	buf = appendTemperature(buf, entry.result.min, opts)
+	buf = append(buf, `,"mean":`...)
+	buf = appendMean(buf, entry.result, opts)
+	buf = append(buf, `,"max":`...)
+	buf = appendTemperature(buf, entry.result.max, opts)
+	buf = append(buf, `,"count":`...)
+	buf = strconv.AppendInt(buf, entry.result.count, 10)
+	if opts.Exact {
+		buf = append(buf, `,"sum":`...)
+		buf = appendSum(buf, entry.result)
+	}
+	if opts.TrackLines {
+		buf = append(buf, `,"first_line":`...)
+		buf = strconv.AppendInt(buf, entry.lines.first, 10)
+		buf = append(buf, `,"last_line":`...)
+		buf = strconv.AppendInt(buf, entry.lines.last, 10)
+	}
+	if opts.TrackExtremes {
+		buf = append(buf, `,"min_line":`...)
+		buf = strconv.AppendInt(buf, entry.extremes.minLine, 10)
+		buf = append(buf, `,"max_line":`...)
+		buf = strconv.AppendInt(buf, entry.extremes.maxLine, 10)
+	}
+	buf = append(buf, '}')
+	return buf, nil
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"bytes"
+	"strconv"
+)
+
+// lintStations finds stations whose names differ only in letter case or
+// surrounding whitespace, which usually means dirty data rather than
+// distinct stations. Every group has at least two names, in output order.
+func lintStations(entries []stationResult) [][][]byte {
+	groups := make(map[string][][]byte)
+	var order []string
+	for _, entry := range entries {
+		key := string(bytes.ToLower(trimSpace(entry.name)))
+		if _, ok := groups[key]; !ok {
+			order = append(order, key)
+		}
+		groups[key] = append(groups[key], entry.name)
+	}
+
+	var duplicates [][][]byte
+	for _, key := range order {
+		if len(groups[key]) > 1 {
+			duplicates = append(duplicates, groups[key])
+		}
+	}
+	return duplicates
+}
+
+// appendLint appends a warning line per group of possible duplicates
+func appendLint(buf []byte, duplicates [][][]byte) []byte {
+	for _, names := range duplicates {
+		buf = append(buf, "possible duplicate stations: "...)
+		for i, name := range names {
+			if i != 0 {
+				buf = append(buf, ", "...)
+			}
+			buf = strconv.AppendQuote(buf, string(name))
+		}
+		buf = append(buf, '\n')
+	}
+	return buf
+}
@@ -0,0 +1,31 @@
+package main
+
+import "testing"
+
+func TestLint(t *testing.T) {
+	fileName := writeFixture(t, "Berlin;1.0\nberlin ;2.0\nBERLIN;3.0\nHamburg;4.0\n Hamburg;5.0\nCracow;6.0\n")
+
+	agg, err := evaluateMmap(fileName, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "possible duplicate stations: \" Hamburg\", \"Hamburg\"\n" +
+		"possible duplicate stations: \"BERLIN\", \"Berlin\", \"berlin \"\n"
+	if got := string(appendLint(nil, lintStations(agg.entries(&Options{})))); got != want {
+		t.Errorf("got  %q\nwant %q", got, want)
+	}
+
+	// lint doesn't merge anything by itself
+	if got := len(agg.entries(&Options{})); got != 6 {
+		t.Errorf("got %d stations, want 6", got)
+	}
+
+	opts := Options{FoldCase: true, Trim: true}
+	agg, err = evaluateMmap(fileName, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := lintStations(agg.entries(&opts)); len(got) != 0 {
+		t.Errorf("with -fold-case -trim got duplicates %q", got)
+	}
+}
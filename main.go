@@ -4,41 +4,176 @@ import (
 	"bytes"
 	"errors"
 	"flag"
-	"hash/maphash"
+	"fmt"
 	"io"
 	"log"
+	"log/slog"
+	"maps"
+	"math"
 	"os"
 	"runtime"
+	"runtime/debug"
 	"runtime/pprof"
+	"runtime/trace"
 	"slices"
 	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"syscall"
 )
 
 var cpuprofile = flag.String("cpuprofile", "", "write cpu profile to file")
 var memprofile = flag.String("memprofile", "", "write memory profile to file")
+var traceFile = flag.String("trace", "", "write an execution trace to file, to see how evenly the workers are loaded")
+var foldCase = flag.Bool("fold-case", false, "treat station names differing only in case as one station")
+var trim = flag.Bool("trim", false, "strip whitespace around station names and temperatures")
+var strict = flag.Bool("strict", false, "fail on the first malformed line instead of skipping it")
+var validate = flag.Bool("validate", false, "only check that every line is well formed, exit 1 if not")
+var format = flag.String("format", formatText, "output format: text, lines for one station per line, range for one station=min..max per line without the mean, json, jsonl for one json object per line, csv, or binary for exact totals that can be merged later")
+var summary = flag.Bool("summary", false, "print an extra ALL=min/avg/max line across all stations")
+var hashSeed = flag.Uint64("hash-seed", 0, "deprecated and ignored, stations are keyed by name")
+var withCount = flag.Bool("with-count", false, "print the number of readings as station=min/avg/max/count in the text and lines formats, station=min..max/count in the range format")
+var cpu = flag.Int("cpu", 0, "set GOMAXPROCS and the number of workers, 0 keeps the defaults")
+var maxNameLength = flag.Int("max-name-length", defaultMaxNameLength, "longest station name accepted, longer ones make the line malformed")
+var chunkSize = flag.String("chunk-size", "auto", "read size of the stream engine, with an optional K, M or G suffix, or auto to scale it with the file size")
+var engine = flag.String("engine", "auto", "aggregation engine: mmap, stream, pread to read huge files in parallel without mapping them, or auto to mmap regular files and stream anything else; gzip files are always streamed")
+var trackLines = flag.Bool("track-lines", false, "record the first and last line of every station, printed by the json and csv formats")
+var trackExtremes = flag.Bool("track-extremes", false, "record the line of the lowest and highest reading of every station, printed by the json format")
+var wide = flag.Bool("wide", false, "accept temperatures with up to four integer digits, like -273.1 or 1013.2")
+var quiet = flag.Bool("quiet", false, "print nothing but the results, not even the skipped line report")
+var verbose = flag.Bool("v", false, "log diagnostics such as the engine picked to stderr")
+var exact = flag.Bool("exact", false, "print unrounded averages, and the sums behind them in the json and csv formats")
+var histogramFlag = flag.Bool("histogram", false, "print how many readings every station has per -histogram-bucket, and some percentiles, as json")
+var skipHeader = flag.Bool("skip-header", false, "ignore the first line of the input, e.g. a station;temperature header")
+var lint = flag.Bool("lint", false, "warn about station names that differ only in case or surrounding whitespace")
+var noNewline = flag.Bool("no-newline", false, "omit the newline at the end of the output")
+var maxLineLengthFlag = flag.String("max-line-length", "0", "fail on lines longer than this, with an optional K, M or G suffix, instead of growing read buffers for them, 0 means no limit")
+var split = flag.String("split", "first", "which delimiter ends the station name: first, or last to allow ';' inside names")
+var sample = flag.Int64("sample", 0, "aggregate only the first N valid lines, for a quick look at a huge file, 0 means all")
+var tail = flag.Int64("tail", 0, "aggregate only the last N lines of the file, without reading the rest, 0 means all")
+var parts = flag.Int("parts", 0, "split the mapped file into this many parts that idle workers take one at a time, 0 gives every worker one part")
+var rowsPerWorker = flag.Int64("rows-per-worker", 0, "have the mmap engine start a worker per this many rows, estimated from the line length, instead of a fixed number, 0 keeps -cpu")
+var precision = flag.Int("precision", 1, "number of decimals printed for min, mean and max")
+var compare = flag.Bool("compare-engines", false, "run every engine on the input and exit 1 with the first differing station if they disagree")
+var scale = flag.Int64("scale", 0, "read temperatures as integers in 1/scale degrees, e.g. 10 for 123 meaning 12.3, 0 to read decimals like 12.3")
+var limitMemory = flag.Bool("limit-memory", false, "trade speed for a small footprint: stream with one worker and about 4 MiB of buffers, plus some 200 bytes per station")
+var maxStations = flag.Int("max-stations", 0, "fail if the input has more stations than this, 0 means no limit")
+var version = flag.Bool("version", false, "print the version, Go version and VCS revision of this build and exit")
+var noDecimal = flag.Bool("no-decimal", false, "read temperatures as whole degrees without a decimal point, like -12, with a faster parser")
+var bestEffort = flag.Bool("best-effort", false, "if a worker fails, still print the results of the others, then exit 1")
+var files = flag.Int("files", 0, "with several input files, aggregate this many at once, 0 shares the CPUs out between files")
+var threadsPerFile = flag.Int("threads-per-file", 0, "with several input files, the workers aggregating each, 0 shares the CPUs out between files")
+var top = flag.Int("top", 0, "print only the N stations with the highest -top-by, 0 prints all")
+var topBy = flag.String("top-by", topByMax, "metric -top ranks by: min, mean, max or count")
+var sortFlag = flag.String("sort", "", "order of the output: name, min, max, avg or count, ties in name order; unset prints by name, or with -top by rank")
+var order = flag.String("order", "", "direction of -sort: asc or desc, unset is asc")
+var exclude = flag.String("exclude", "", "stations to leave out of the output but not the summary, comma separated, or @file with one per line")
+var histogramBucket = flag.String("histogram-bucket", "0.1", "width of the -histogram buckets in degrees, with one decimal like 0.5 or 5.0")
+var validateUTF8 = flag.Bool("validate-utf8", false, "report station names that aren't valid UTF-8, with -strict fail on them")
+var collateFlag = flag.String("collate", "", "sort station names by the Unicode collation of this language, like en or sv, instead of byte order; needs a build with -tags collate")
+var wideSum = flag.Bool("wide-sum", false, "sum readings in 128 bits, for inputs of more than 10^13 rows")
+var stationsFile = flag.String("stations", "", "file listing every station, one per line, to skip discovery; lines of other stations are malformed")
+var checksum = flag.String("checksum", "", "print a crc32 or sha256 checksum of the input file to stderr, computed while it is read")
+var dropIncompleteFlag = flag.Bool("drop-incomplete", false, "ignore a last line without a newline that doesn't parse, as a killed writer leaves it, instead of reporting it; -strict still fails on it")
+var quoted = flag.Bool("quoted", false, "allow station names in double quotes, which may contain ';'")
 
 const (
+	// numberOfMaxStations is the most stations the spec allows. Tables are
+	// sized for an estimate of at most this many up front, but larger files
+	// work as well.
 	numberOfMaxStations = 10_000
 	workerCount         = 10
+
+	// discoveryWindow is how much of a mapped file is scanned for station
+	// names before the workers start
+	discoveryWindow = 5_000_000
+
+	// limitedChunkSize and limitedQueue tune evaluateLimited. Its pool holds
+	// queue + workers + 2 chunks, so its read buffers take limitedBuffers.
+	limitedChunkSize = 1 << 20
+	limitedQueue     = 1
+	limitedBuffers   = (limitedQueue + 1 + 2) * limitedChunkSize
+
+	// maxLineLength is the longest line accepted: a 100 byte name, the
+	// delimiter, -9999.9 with -wide and the newline
+	maxLineLength = 100 + len(";-9999.9\n")
 )
 
-var maphashSeed = maphash.MakeSeed()
+type WorkerResults []workerResult
+
+// workerResult is everything a single worker accumulates
+type workerResult struct {
+	cities cityMap
+	// populated lists the indices into cities that hold readings, in the
+	// order they got their first, so the merge skips the empty ones
+	populated []uint64
+	// missed holds stations that discovery didn't see, keyed by station key
+	missed map[string]*missedStation
+	// histograms is only filled with Options.Histogram
+	histograms map[string]histogram
+	// names owns the names of the missed stations
+	names nameArena
+	// err is a TooManyStationsError once the worker saw more than
+	// Options.MaxStations stations, it aggregates nothing after that
+	err error
+}
 
-type WorkerResults [workerCount][numberOfMaxStations]cityTemperatureInfo
+type missedStation struct {
+	name []byte
+	info cityTemperatureInfo
+}
 
-type cityMap [numberOfMaxStations]cityTemperatureInfo
+// cityMap holds an accumulator per station index. Workers size theirs to
+// the stations discovery found.
+type cityMap []cityTemperatureInfo
 
+// cityTemperatureInfo accumulates the readings of one station. count is kept
+// at 64 bits and saturates instead of wrapping, so a single hot station can
+// never overflow it, however the rest of the struct gets packed.
 type cityTemperatureInfo struct {
 	count int64
 	min   int64
 	max   int64
 	sum   int64
+
+	// sumHigh is the high word of the sum, see addSum
+	sumHigh int64
+}
+
+// aggregation is the merged outcome of an engine run, ready to be printed
+type aggregation struct {
+	stationNames     [][]byte // sorted
+	stationSymbolMap map[string]uint64
+	results          cityMap
+	report           Report
+
+	// lines holds the line span of every station keyed by station key, only
+	// with Options.TrackLines
+	lines map[string]lineSpan
+
+	// extremes holds the lines of the lowest and highest reading of every
+	// station keyed by station key, only with Options.TrackExtremes
+	extremes map[string]extremeLines
+
+	// histograms holds the histogram of every station keyed by station key,
+	// only with Options.Histogram
+	histograms map[string]histogram
+
+	// checksum is the hash of the input file, only with Options.Checksum
+	checksum []byte
 }
 
 func main() {
 	flag.Parse()
+	if *version {
+		info, ok := debug.ReadBuildInfo()
+		if !ok {
+			log.Fatal("no build info, built without module support")
+		}
+		_, _ = os.Stdout.Write(appendVersion(nil, info))
+		return
+	}
 	if *cpuprofile != "" {
 		f, err := os.Create("./profiles/" + *cpuprofile)
 		if err != nil {
@@ -50,8 +185,194 @@ func main() {
 		}
 		defer pprof.StopCPUProfile()
 	}
+	if *traceFile != "" {
+		stop, err := startTrace("./profiles/" + *traceFile)
+		if err != nil {
+			log.Fatal("could not start trace: ", err)
+		}
+		defer stop()
+	}
+
+	opts := Options{
+		FoldCase: *foldCase,
+		Trim:     *trim,
+		Strict:   *strict,
+		Wide:     *wide,
+		Summary:  *summary,
+		HashSeed: *hashSeed,
+		Exact:    *exact,
+		Format:   *format,
+
+		MaxNameLength:  *maxNameLength,
+		TrackLines:     *trackLines,
+		TrackExtremes:  *trackExtremes,
+		Histogram:      *histogramFlag,
+		SkipHeader:     *skipHeader,
+		NoNewline:      *noNewline,
+		Quoted:         *quoted,
+		Sample:         *sample,
+		Tail:           *tail,
+		Parts:          *parts,
+		RowsPerWorker:  *rowsPerWorker,
+		MaxStations:    *maxStations,
+		Files:          *files,
+		Top:            *top,
+		ValidateUTF8:   *validateUTF8,
+		Collate:        *collateFlag,
+		WideSum:        *wideSum,
+		TopBy:          *topBy,
+		Sort:           *sortFlag,
+		Order:          *order,
+		NoDecimal:      *noDecimal,
+		BestEffort:     *bestEffort,
+		Checksum:       *checksum,
+		WithCount:      *withCount,
+		DropIncomplete: *dropIncompleteFlag,
+	}
+	switch {
+	case *quiet:
+	case *verbose:
+		opts.Logger = slog.New(slog.NewTextHandler(os.Stderr, nil))
+	default:
+		opts.Logger = slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: slog.LevelWarn}))
+	}
+	if *cpu > 0 {
+		runtime.GOMAXPROCS(*cpu)
+		opts.Workers = *cpu
+	}
+	switch *format {
+	case formatText, formatLines, formatRange, formatJSON, formatJSONL, formatCSV, formatBinary:
+	default:
+		log.Fatalf("unknown -format %q", *format)
+	}
+	switch {
+	case *precision < 0:
+		log.Fatalf("-precision %d must not be negative", *precision)
+	case *precision == 0:
+		opts.Precision = -1
+	default:
+		opts.Precision = *precision
+	}
+	bucket, ok := wideStringToIntParser([]byte(*histogramBucket))
+	if !ok || bucket <= 0 {
+		log.Fatalf("invalid -histogram-bucket %q, want a positive width with one decimal like 0.5", *histogramBucket)
+	}
+	opts.HistogramBucket = bucket
+	if *stationsFile != "" {
+		var err error
+		if opts.Stations, err = parseStationList("@" + *stationsFile); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if *exclude != "" {
+		var err error
+		if opts.Exclude, err = parseStationList(*exclude); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if *collateFlag != "" {
+		if err := validateCollate(*collateFlag); err != nil {
+			log.Fatalf("-collate %q: %v", *collateFlag, err)
+		}
+	}
+	if err := validateTopBy(*topBy); err != nil {
+		log.Fatal(err)
+	}
+	if err := validateSort(*sortFlag, *order); err != nil {
+		log.Fatal(err)
+	}
+	if err := validateChecksum(*checksum); err != nil {
+		log.Fatal(err)
+	}
+	if *checksum != "" && (*sample > 0 || *tail > 0) {
+		log.Fatal("-checksum hashes the whole file, it can't be combined with -sample or -tail")
+	}
+	if *sample > 0 && *tail > 0 {
+		log.Fatal("-sample and -tail exclude each other")
+	}
+	if *scale < 0 {
+		log.Fatalf("-scale %d must not be negative", *scale)
+	}
+	opts.Scale = *scale
+	switch *split {
+	case "first":
+	case "last":
+		opts.SplitLast = true
+	default:
+		log.Fatalf("unknown -split %q, want first or last", *split)
+	}
+
+	if len(flag.Args()) > 1 && (*validate || *compare) {
+		log.Fatal("-validate and -compare-engines take a single file")
+	}
+
+	if *validate {
+		report, err := validateFile(flag.Args()[0], opts)
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Printf("%d lines, %d malformed\n", report.TotalLines, report.SkippedLines)
+		if report.SkippedLines > 0 {
+			if !*quiet {
+				_, _ = os.Stderr.Write(appendReport(nil, report))
+			}
+			os.Exit(1)
+		}
+		return
+	}
+
+	// zero lets the streaming engine pick a size per file
+	var (
+		size int
+		err  error
+	)
+	if *chunkSize != "auto" {
+		if size, err = parseSize(*chunkSize); err != nil {
+			log.Fatal(err)
+		}
+		if size <= maxLineLength {
+			log.Fatalf("-chunk-size %s must be larger than the longest line (%d bytes)", *chunkSize, maxLineLength)
+		}
+	}
+	if *maxLineLengthFlag != "0" {
+		if opts.MaxLineLength, err = parseSize(*maxLineLengthFlag); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	if *compare {
+		stations, err := compareEngines(flag.Args()[0], size, opts)
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Printf("engines agree on %d stations\n", stations)
+		return
+	}
 
-	evaluateMmap(flag.Args()[0], workerCount, 16*1024*1024, false)
+	var agg *aggregation
+	if len(flag.Args()) > 1 {
+		if *threadsPerFile > 0 {
+			opts.Workers = *threadsPerFile
+		}
+		agg, err = evaluateFiles(flag.Args(), *engine, size, opts)
+	} else if *limitMemory {
+		agg, err = evaluateLimited(flag.Args()[0], opts)
+	} else {
+		agg, err = run(flag.Args()[0], *engine, size, opts)
+	}
+	if agg == nil {
+		log.Fatal(err)
+	}
+	if err := printResults(os.Stdout, os.Stderr, agg, &opts, *quiet); err != nil {
+		log.Fatal(err)
+	}
+	if err != nil {
+		// partial results with -best-effort
+		log.Fatal(err)
+	}
+	if *lint {
+		_, _ = os.Stderr.Write(appendLint(nil, lintStations(agg.entries(&opts))))
+	}
 
 	if *memprofile != "" {
 		f, err := os.Create("./profiles/" + *memprofile)
@@ -66,14 +387,486 @@ func main() {
 	}
 }
 
-func evaluate(fileName string, chanSize int, chunkSize int, printResult bool) error {
-	workers := runtime.NumCPU() - 1
+// evaluateLimited is the streaming engine set up for the smallest
+// footprint, for -limit-memory: one worker, one queued chunk and small
+// chunks. Its read buffers stay within limitedBuffers bytes, and lines that
+// wouldn't fit a chunk fail the run unless opts.MaxLineLength is set.
+// On top come the station tables, roughly 200 bytes per station. Gzip
+// files are decompressed into the same chunks.
+func evaluateLimited(fileName string, opts Options) (*aggregation, error) {
+	opts.Workers = 1
+	if opts.MaxLineLength == 0 {
+		opts.MaxLineLength = limitedChunkSize
+	}
+	gz, err := isGzipFile(fileName)
+	if err != nil {
+		return nil, err
+	}
+	if gz {
+		if opts.Tail > 0 {
+			return nil, fmt.Errorf("-tail can't seek in %s, it is gzip compressed", fileName)
+		}
+		opts.logger().Info("aggregating", "file", fileName, "engine", "gzip")
+		return evaluateGzip(fileName, limitedQueue, limitedChunkSize, opts)
+	}
+	if opts.Tail > 0 {
+		// only the tail is read, a window at a time
+		opts.logger().Info("aggregating the tail", "file", fileName, "lines", opts.Tail)
+		return evaluateTail(fileName, opts)
+	}
+	return evaluate(fileName, limitedQueue, limitedChunkSize, opts)
+}
+
+// run aggregates fileName with the named engine
+func run(fileName string, engine string, chunkSize int, opts Options) (*aggregation, error) {
+	// compressed files can only be streamed, whatever the engine
+	gz, err := isGzipFile(fileName)
+	if err != nil {
+		return nil, err
+	}
+	if gz {
+		if opts.Tail > 0 {
+			return nil, fmt.Errorf("-tail can't seek in %s, it is gzip compressed", fileName)
+		}
+		opts.logger().Info("aggregating", "file", fileName, "engine", "gzip")
+		return evaluateGzip(fileName, workerCount, chunkSize, opts)
+	}
+	if opts.Tail > 0 {
+		opts.logger().Info("aggregating the tail", "file", fileName, "lines", opts.Tail)
+		return evaluateTail(fileName, opts)
+	}
+	if engine == "auto" {
+		if engine, err = selectEngine(fileName); err != nil {
+			return nil, err
+		}
+	}
+	opts.logger().Info("aggregating", "file", fileName, "engine", engine)
+
+	var agg *aggregation
+	switch engine {
+	case "mmap":
+		agg, err = evaluateMmap(fileName, opts)
+	case "stream":
+		agg, err = evaluate(fileName, workerCount, chunkSize, opts)
+	case "pread":
+		agg, err = evaluatePread(fileName, opts)
+	default:
+		return nil, fmt.Errorf("unknown engine %q, want auto, mmap, stream or pread", engine)
+	}
+	if err == nil && opts.Checksum != "" && agg.checksum == nil {
+		// the engine didn't read the file in order, hash it separately
+		agg.checksum, err = fileChecksum(fileName, opts.Checksum)
+	}
+	return agg, err
+}
+
+// printResults writes the results to stdout and, unless quiet, the report
+// of skipped lines to stderr
+func printResults(stdout, stderr io.Writer, agg *aggregation, opts *Options, quiet bool) error {
+	if err := writeResults(stdout, agg, opts); err != nil {
+		return err
+	}
+	if agg.checksum != nil {
+		// asked for explicitly, so printed even when quiet
+		if _, err := stderr.Write(appendChecksum(nil, opts.Checksum, agg.checksum)); err != nil {
+			return err
+		}
+	}
+	if quiet {
+		return nil
+	}
+	if opts.Sample > 0 && agg.report.ParsedLines == opts.Sample {
+		if _, err := fmt.Fprintf(stderr, "results are a sample of the first %d valid lines\n", opts.Sample); err != nil {
+			return err
+		}
+	}
+	if opts.Tail > 0 && agg.report.TotalLines == opts.Tail {
+		if _, err := fmt.Fprintf(stderr, "results are a sample of the last %d lines\n", opts.Tail); err != nil {
+			return err
+		}
+	}
+	if agg.report.SkippedLines > 0 || len(agg.report.InvalidNames) > 0 {
+		_, err := stderr.Write(appendReport(nil, agg.report))
+		return err
+	}
+	return nil
+}
+
+// startTrace starts an execution trace into fileName. The returned function
+// stops it and closes the file.
+func startTrace(fileName string) (stop func() error, err error) {
+	f, err := os.Create(fileName)
+	if err != nil {
+		return nil, err
+	}
+	if err := trace.Start(f); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return func() error {
+		trace.Stop()
+		return f.Close()
+	}, nil
+}
+
+// appendVersion appends the module version, the Go version and the
+// settings of the build, like the VCS revision and the flags, one per line
+func appendVersion(buf []byte, info *debug.BuildInfo) []byte {
+	buf = fmt.Appendf(buf, "%s %s\n", info.Main.Path, info.Main.Version)
+	buf = fmt.Appendf(buf, "%s\n", info.GoVersion)
+	for _, setting := range info.Settings {
+		buf = fmt.Appendf(buf, "%s=%s\n", setting.Key, setting.Value)
+	}
+	return buf
+}
+
+// selectEngine picks mmap for regular files and the streaming engine for
+// pipes, devices and anything else that can't be mapped
+func selectEngine(fileName string) (string, error) {
+	stat, err := os.Stat(fileName)
+	if err != nil {
+		return "", err
+	}
+	if stat.Mode().IsRegular() {
+		return "mmap", nil
+	}
+	return "stream", nil
+}
+
+// parseStationList parses a comma separated list of station names, or with
+// a leading @ the name of a file listing one station per line
+func parseStationList(s string) ([]string, error) {
+	if fileName, ok := strings.CutPrefix(s, "@"); ok {
+		data, err := os.ReadFile(fileName)
+		if err != nil {
+			return nil, err
+		}
+		return strings.FieldsFunc(string(data), func(r rune) bool { return r == '\n' || r == '\r' }), nil
+	}
+	return strings.Split(s, ","), nil
+}
+
+// parseSize parses a byte count such as 65536, 64K, 16M or 1G
+func parseSize(s string) (int, error) {
+	multiplier, digits := 1, s
+	if s != "" {
+		switch s[len(s)-1] {
+		case 'k', 'K':
+			multiplier = 1 << 10
+		case 'm', 'M':
+			multiplier = 1 << 20
+		case 'g', 'G':
+			multiplier = 1 << 30
+		}
+	}
+	if multiplier != 1 {
+		digits = s[:len(s)-1]
+	}
+
+	n, err := strconv.Atoi(digits)
+	if err != nil || n <= 0 {
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+	return n * multiplier, nil
+}
+
+// add folds a single temperature reading into the accumulator
+func (c *cityTemperatureInfo) add(temperature int64) {
+	if c.count == 0 {
+		*c = cityTemperatureInfo{
+			count: 1,
+			min:   temperature,
+			max:   temperature,
+			sum:   temperature,
+		}
+		return
+	}
+
+	c.count = addCount(c.count, 1)
+	c.sum += temperature
+	if temperature < c.min {
+		c.min = temperature
+	}
+	if temperature > c.max {
+		c.max = temperature
+	}
+}
+
+// merge folds another accumulator into this one
+func (c *cityTemperatureInfo) merge(other cityTemperatureInfo) {
+	if other.count == 0 {
+		return
+	}
+	if c.count == 0 {
+		*c = other
+		return
+	}
+
+	c.count = addCount(c.count, other.count)
+	c.sumHigh, c.sum = addSum(c.sumHigh+other.sumHigh, c.sum, other.sum)
+	if other.min < c.min {
+		c.min = other.min
+	}
+	if other.max > c.max {
+		c.max = other.max
+	}
+}
+
+// addCount adds two non-negative reading counts, saturating at
+// math.MaxInt64 rather than wrapping around to a negative count
+func addCount(a, b int64) int64 {
+	if a > math.MaxInt64-b {
+		return math.MaxInt64
+	}
+	return a + b
+}
+
+// aggregateChunk folds every line of chunk into result. The chunk must start
+// at the beginning of a line; a final line without '\n' is processed as well.
+// Lines without a delimiter or with an unparsable temperature are skipped and
+// recorded in the returned report. In strict mode the chunk is abandoned at
+// the first such line.
+func aggregateChunk(chunk []byte, seq int, stationSymbolMap map[string]uint64, result *workerResult, opts *Options) chunkReport {
+	report := chunkReport{seq: seq}
+	if result.err != nil {
+		return report
+	}
+	chunk = report.skipHeader(chunk, opts)
+	if n := len(stationSymbolMap); len(result.cities) < n {
+		result.cities = append(result.cities, make(cityMap, n-len(result.cities))...)
+	}
+
+	var line []byte
+	for len(chunk) > 0 {
+		line, chunk = cutLine(chunk)
+		report.lines++
+
+		name, value, ok := opts.splitLine(line)
+		if !ok {
+			report.skip(line)
+			if opts.Strict {
+				break
+			}
+			continue
+		}
+
+		temperature, ok := opts.parseTemperature(value)
+		if !ok {
+			report.skip(line)
+			if opts.Strict {
+				break
+			}
+			continue
+		}
+
+		key := opts.stationKey(name)
+		stationIndex, known := stationSymbolMap[string(key)]
+		if !known && opts.Stations != nil {
+			// only the stations of the list are aggregated
+			report.skip(line)
+			if opts.Strict {
+				break
+			}
+			continue
+		}
+		if opts.TrackLines {
+			report.see(key)
+		}
+		if opts.TrackExtremes {
+			report.seeExtremes(key, temperature)
+		}
+		if known {
+			result.add(stationIndex, temperature, opts.WideSum)
+		} else {
+			result.addMissed(key, name, temperature, opts.WideSum)
+		}
+		if stations := len(result.populated) + len(result.missed); opts.MaxStations > 0 && stations > opts.MaxStations {
+			// this worker alone saw too many, stop before its tables grow
+			// any further
+			result.err = &TooManyStationsError{Stations: stations, MaxStations: opts.MaxStations}
+			break
+		}
+		if opts.Histogram {
+			result.addToHistogram(key, temperature, opts.HistogramBucket)
+		}
+	}
+
+	return report
+}
+
+// add records a reading for a station that discovery saw
+func (r *workerResult) add(stationIndex uint64, temperature int64, wideSum bool) {
+	city := &r.cities[stationIndex]
+	if city.count == 0 {
+		r.populated = append(r.populated, stationIndex)
+	}
+	if wideSum {
+		city.addWide(temperature)
+	} else {
+		city.add(temperature)
+	}
+}
+
+// addMissed records a reading for a station that discovery didn't see
+func (r *workerResult) addMissed(key, name []byte, temperature int64, wideSum bool) {
+	if r.missed == nil {
+		r.missed = make(map[string]*missedStation)
+	}
+
+	station, ok := r.missed[string(key)]
+	if !ok {
+		station = &missedStation{name: r.names.clone(name)}
+		r.missed[string(key)] = station
+	}
+	if wideSum {
+		station.info.addWide(temperature)
+	} else {
+		station.info.add(temperature)
+	}
+}
+
+// mergeWorkerResults folds the results of all workers into one cityMap.
+// Stations missed by discovery get registered in stationSymbolMap and
+// appended to stationNames, in which case stationNames is no longer sorted.
+func mergeWorkerResults(workerResults WorkerResults, stationNames [][]byte, stationSymbolMap map[string]uint64) (cityMap, [][]byte) {
+	cityMapResults := make(cityMap, len(stationNames))
+	for w := range workerResults {
+		// a worker on a few stations of many only visits its own
+		for _, i := range workerResults[w].populated {
+			cityMapResults[i].merge(workerResults[w].cities[i])
+		}
+	}
+
+	for w := range workerResults {
+		// registration order decides the index, so keep it independent of
+		// map iteration order
+		missed := workerResults[w].missed
+		if len(missed) == 0 {
+			continue
+		}
+		for _, key := range slices.Sorted(maps.Keys(missed)) {
+			station := missed[key]
+			stationIndex, ok := stationSymbolMap[key]
+			if !ok {
+				stationIndex = uint64(len(stationNames))
+				stationSymbolMap[key] = stationIndex
+				stationNames = append(stationNames, station.name)
+				cityMapResults = append(cityMapResults, cityTemperatureInfo{})
+			}
+			cityMapResults[stationIndex].merge(station.info)
+		}
+	}
+
+	return cityMapResults, stationNames
+}
+
+type chunk struct {
+	seq  int
+	data []byte
+}
+
+// chunkPool hands out at most n buffers of size bytes, blocking while all
+// of them are in use. Buffers are allocated on first use.
+type chunkPool struct {
+	free chan []byte
+	size int
+}
+
+func newChunkPool(n, size int) *chunkPool {
+	p := &chunkPool{free: make(chan []byte, n), size: size}
+	for i := 0; i < n; i++ {
+		p.free <- nil
+	}
+	return p
+}
+
+func (p *chunkPool) get() []byte {
+	buf := <-p.free
+	if buf == nil {
+		buf = make([]byte, p.size)
+	}
+	return buf
+}
+
+// put returns a buffer, or any slice of it starting at its beginning
+func (p *chunkPool) put(buf []byte) {
+	p.free <- buf[:cap(buf)]
+}
+
+// evaluate is the streaming engine on a file. Like the mmap engine it
+// aggregates the file as it was when opened: anything appended while it is
+// read, which may end in a half written line, is left out. A chunkSize of
+// zero picks one from the file size, see adaptiveChunkSize.
+func evaluate(fileName string, chanSize int, chunkSize int, opts Options) (*aggregation, error) {
+	file, err := os.Open(fileName)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	r, err := snapshot(file)
+	if err != nil {
+		return nil, err
+	}
+	if chunkSize == 0 {
+		chunkSize = defaultChunkSize
+		if limited, ok := r.(*io.LimitedReader); ok {
+			chunkSize = adaptiveChunkSize(limited.N, opts.Workers)
+		}
+	}
+	if opts.Checksum == "" {
+		return evaluateReader(r, chanSize, chunkSize, opts)
+	}
+	// hash every chunk as the reader fills it
+	h := newChecksum(opts.Checksum)
+	agg, err := evaluateReader(io.TeeReader(r, h), chanSize, chunkSize, opts)
+	if err != nil {
+		return nil, err
+	}
+	agg.checksum = h.Sum(nil)
+	return agg, nil
+}
+
+// snapshot returns a reader of f that stops at the size f has now, if it is
+// a regular file. Pipes and devices have no size to stop at.
+func snapshot(f *os.File) (io.Reader, error) {
+	stat, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if !stat.Mode().IsRegular() {
+		return f, nil
+	}
+	return io.LimitReader(f, stat.Size()), nil
+}
+
+// evaluateReader is the streaming engine: it reads r in chunks of chunkSize,
+// cut at the last newline, and hands them to the workers through a channel
+// of chanSize chunks
+func evaluateReader(r io.Reader, chanSize int, chunkSize int, opts Options) (*aggregation, error) {
+	workers := opts.Workers
+	if workers == 0 {
+		workers = min(max(runtime.NumCPU()-1, 1), workerCount)
+	}
 	var (
-		stationNames     = make([][]byte, 0, numberOfMaxStations)
-		stationSymbolMap = make(map[uint64]uint64, numberOfMaxStations)
-		workerResults    = WorkerResults{}
+		// replaced by the discovery on the first chunk
+		stationNames     [][]byte
+		stationSymbolMap = make(map[string]uint64)
+		workerResults    = make(WorkerResults, workers)
+		workerReports    = make([][]chunkReport, workers)
 	)
-	byChan := make(chan []byte, chanSize)
+	if opts.Stations != nil {
+		stationNames, stationSymbolMap = seedStations(&opts)
+	}
+	// every chunk is read into a buffer of the pool: up to chanSize queued,
+	// one per worker and two for the reader, which fills the next buffer
+	// with the end of the current one before handing it off. So peak memory
+	// is (chanSize + workers + 2) * chunkSize, however slow the workers are,
+	// as long as no line is longer than chunkSize.
+	pool := newChunkPool(chanSize+workers+2, chunkSize)
+	byChan := make(chan chunk, chanSize)
+	// set by a worker that gave up, so the rest of the input isn't read
+	var stopped atomic.Bool
 
 	wg := sync.WaitGroup{}
 	wg.Add(workers)
@@ -81,381 +874,552 @@ func evaluate(fileName string, chanSize int, chunkSize int, printResult bool) er
 	for i := 0; i < workers; i++ {
 		go func(workerID int) {
 			defer wg.Done()
-			for by := range byChan {
-				var stationID uint64
-				var startIndex int
-				cityM := &workerResults[workerID]
-
-				for i, char := range by {
-					switch char {
-					case ';':
-						stationID = maphash.Bytes(maphashSeed, by[startIndex:i])
-						startIndex = i + 1
-					case '\n':
-						if (i-startIndex) > 1 && stationID != 0 {
-							temperature := customStringToIntParser(by[startIndex:i])
-							startIndex = i + 1
-
-							stationIndex := stationSymbolMap[stationID]
-
-							if cityM[stationIndex].count == 0 {
-								cityM[stationIndex] = cityTemperatureInfo{
-									count: 1,
-									min:   temperature,
-									max:   temperature,
-									sum:   temperature,
-								}
-							} else {
-								cityM[stationIndex].count++
-								cityM[stationIndex].sum += temperature
-								if temperature < cityM[stationIndex].min {
-									cityM[stationIndex].min = temperature
-								}
-								if temperature > cityM[stationIndex].max {
-									cityM[stationIndex].max = temperature
-								}
-							}
-						}
-					}
+			for c := range byChan {
+				report := aggregateChunk(c.data, c.seq, stationSymbolMap, &workerResults[workerID], &opts)
+				workerReports[workerID] = append(workerReports[workerID], report)
+				pool.put(c.data)
+				if workerResults[workerID].err != nil {
+					stopped.Store(true)
 				}
 			}
 		}(i)
 	}
 
-	{
-		file, err := os.Open(fileName)
-		if err != nil {
-			panic(err)
-		}
-		defer file.Close()
-
-		buf := make([]byte, chunkSize)
-		leftOver := make([]byte, 0, chunkSize)
+	if opts.Sample > 0 {
+		r = &sampleReader{r: r, lines: opts.Sample, opts: &opts}
+	}
 
-		firstIteration := true
+	var readErr error
+	{
+		buf := pool.get()
+		filled := 0
+		seq := 0
+		var offset int64 // of buf in the input
+
+		for !stopped.Load() {
+			n, err := io.ReadFull(r, buf[filled:])
+			filled += n
+			eof := errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF)
+			if err != nil && !eof {
+				readErr = err
+				break
+			}
 
-		for {
-			readTotal, err := file.Read(buf)
-			if err != nil {
-				if errors.Is(err, io.EOF) {
+			end := bytes.LastIndexByte(buf[:filled], '\n') + 1
+			if eof {
+				// the last line may lack its newline, it ends at EOF then
+				end = len(dropIncomplete(buf[:filled], &opts))
+			}
+			if end == 0 && !eof {
+				// a line longer than the buffer, grow it until the line fits
+				if opts.MaxLineLength > 0 && filled >= opts.MaxLineLength {
+					readErr = &LineTooLongError{Offset: offset, MaxLineLength: opts.MaxLineLength}
 					break
 				}
-				panic(err)
+				buf = append(buf, make([]byte, len(buf))...)
+				continue
 			}
-			buf = buf[:readTotal]
-
-			toSend := make([]byte, readTotal)
-			copy(toSend, buf)
-
-			lastNewLineIndex := bytes.LastIndex(buf, []byte{'\n'})
 
-			toSend = append(leftOver, buf[:lastNewLineIndex+1]...)
-			leftOver = make([]byte, len(buf[lastNewLineIndex+1:]))
-			copy(leftOver, buf[lastNewLineIndex+1:])
-
-			if firstIteration {
-				stationNames, stationSymbolMap = getAllStationNames(toSend)
-				firstIteration = false
+			// the partial line at the end starts the next chunk
+			next := pool.get()
+			for len(next) < filled-end {
+				next = append(next, make([]byte, len(next))...)
+			}
+			filled = copy(next, buf[end:filled])
+			offset += int64(end)
+			if end > 0 {
+				if seq == 0 && opts.Stations == nil {
+					stationNames, stationSymbolMap = getAllStationNames(buf[:end], &opts)
+				}
+				byChan <- chunk{seq: seq, data: buf[:end]}
+				seq++
+			} else {
+				pool.put(buf)
 			}
+			buf = next
 
-			byChan <- toSend
+			if eof {
+				break
+			}
 		}
 	}
 	close(byChan)
 	wg.Wait()
+	if readErr != nil {
+		return nil, readErr
+	}
 
-	var cityMapResults cityMap
-	for _, t := range workerResults {
-		for i, tempInfo := range t {
-			if cityMapResults[i].count == 0 {
-				cityMapResults[i] = tempInfo
-			} else {
-				cityMapResults[i].count += tempInfo.count
-				cityMapResults[i].sum += tempInfo.sum
-				if tempInfo.min < cityMapResults[i].min {
-					cityMapResults[i].min = tempInfo.min
-				}
-				if tempInfo.max > cityMapResults[i].max {
-					cityMapResults[i].max = tempInfo.max
-				}
-			}
+	return finishAggregation(workerResults, slices.Concat(workerReports...), stationNames, stationSymbolMap, &opts)
+}
+
+// finishAggregation reduces the per-worker state of a completed run
+func finishAggregation(workerResults WorkerResults, chunkReports []chunkReport, stationNames [][]byte, stationSymbolMap map[string]uint64, opts *Options) (*aggregation, error) {
+	for _, result := range workerResults {
+		if result.err != nil {
+			return nil, result.err
 		}
 	}
+	report := buildReport(chunkReports)
+	if opts.Strict && report.SkippedLines > 0 {
+		return nil, report.Malformed[0].err()
+	}
 
-	slices.SortFunc(stationNames, func(a, b []byte) int {
-		return bytes.Compare(a, b)
-	})
+	var lines map[string]lineSpan
+	if opts.TrackLines {
+		lines = buildLineSpans(chunkReports)
+	}
+	var extremes map[string]extremeLines
+	if opts.TrackExtremes {
+		extremes = buildExtremeLines(chunkReports)
+	}
+	var histograms map[string]histogram
+	if opts.Histogram {
+		histograms = mergeHistograms(workerResults)
+	}
 
-	var result cityTemperatureInfo
+	discovered := len(stationNames)
+	cityMapResults, stationNames := mergeWorkerResults(workerResults, stationNames, stationSymbolMap)
+	if missed := len(stationNames) - discovered; missed > 0 {
+		opts.logger().Debug("stations registered after discovery", "discovered", discovered, "missed", missed)
+	}
+
+	if opts.MaxStations > 0 {
+		stations := 0
+		for _, result := range cityMapResults {
+			if result.count > 0 {
+				stations++
+			}
+		}
+		if stations > opts.MaxStations {
+			return nil, &TooManyStationsError{Stations: stations, MaxStations: opts.MaxStations}
+		}
+	}
 
-	buf := make([]byte, 0, 50000)
-	buf = append(buf, '{')
+	// engines may have sorted the discovered names already, but stations
+	// registered during the merge are appended unsorted
+	sortStationNames(stationNames)
+	if opts.Collate != "" {
+		if err := collateStationNames(stationNames, opts.Collate); err != nil {
+			return nil, err
+		}
+	}
 
-	// Print workerResults {station1=min/avg/max, station2=min/avg/max, ...}
-	for i, station := range stationNames {
-		if i != 0 {
-			buf = append(buf, ',', ' ')
+	if opts.ValidateUTF8 {
+		report.InvalidNames = invalidNames(stationNames)
+		if opts.Strict && len(report.InvalidNames) > 0 {
+			return nil, fmt.Errorf("station name %q is not valid UTF-8", report.InvalidNames[0])
 		}
+	}
 
-		result = cityMapResults[stationSymbolMap[maphash.Bytes(maphashSeed, station)]]
+	return &aggregation{
+		stationNames:     stationNames,
+		stationSymbolMap: stationSymbolMap,
+		results:          cityMapResults,
+		report:           report,
+		lines:            lines,
+		extremes:         extremes,
+		histograms:       histograms,
+	}, nil
+}
+
+// stationNameSorts counts the sorts sortStationNames didn't get to skip
+var stationNameSorts atomic.Int64
 
-		buf = append(buf, station...)
-		buf = append(buf, '=')
-		buf = append(buf, strconv.FormatFloat(float64(result.min)/10, 'f', 1, 64)...)
-		buf = append(buf, '/')
-		buf = append(buf, strconv.FormatFloat(float64(result.sum)/(float64(result.count)*10), 'f', 1, 64)...)
-		buf = append(buf, '/')
-		buf = append(buf, strconv.FormatFloat(float64(result.max)/10, 'f', 1, 64)...)
+// sortStationNames sorts names for the output. Files grouped by station
+// list them in order already, then the sort is skipped.
+func sortStationNames(names [][]byte) {
+	if slices.IsSortedFunc(names, bytes.Compare) {
+		return
 	}
+	stationNameSorts.Add(1)
+	slices.SortFunc(names, bytes.Compare)
+}
 
-	buf = append(buf, '}', '\n')
-	if printResult {
-		_, _ = os.Stdout.Write(buf)
+// discoverStations registers the stations named in the first
+// discoveryWindow bytes of data. Workers register any station that only
+// shows up later. With Options.Stations there is nothing to discover.
+func discoverStations(data []byte, opts *Options) ([][]byte, map[string]uint64) {
+	if opts.Stations != nil {
+		return seedStations(opts)
 	}
-	return nil
+	discovery := data[:min(len(data), discoveryWindow)]
+	discovery = discovery[:bytes.LastIndexByte(discovery, '\n')+1]
+	return getAllStationNames(discovery, opts)
 }
 
-func getAllStationNames(by []byte) ([][]byte, map[uint64]uint64) {
-	stationNames := make([][]byte, 0, numberOfMaxStations)
-	stationSymbolMap := make(map[uint64]uint64, numberOfMaxStations)
-	var startIndex int
-
-	var id uint64
-	for i, char := range by {
-		switch char {
-		case ';':
-			stationID := maphash.Bytes(maphashSeed, by[startIndex:i])
-			if _, ok := stationSymbolMap[stationID]; !ok {
-				stationNames = append(stationNames, by[startIndex:i])
-				stationSymbolMap[stationID] = id
-				id++
-			}
-		case '\n':
-			startIndex = i + 1
+// seedStations registers the stations of Options.Stations, in list order
+func seedStations(opts *Options) ([][]byte, map[string]uint64) {
+	stationNames := make([][]byte, 0, len(opts.Stations))
+	stationSymbolMap := make(map[string]uint64, len(opts.Stations))
+	for _, name := range opts.Stations {
+		key := string(opts.stationKey([]byte(name)))
+		if _, ok := stationSymbolMap[key]; !ok {
+			stationSymbolMap[key] = uint64(len(stationNames))
+			stationNames = append(stationNames, []byte(name))
+		}
+	}
+	return stationNames, stationSymbolMap
+}
+
+// discoveryPasses counts the calls of getAllStationNames
+var discoveryPasses atomic.Int64
+
+func getAllStationNames(by []byte, opts *Options) ([][]byte, map[string]uint64) {
+	discoveryPasses.Add(1)
+	stations := estimateStations(by, opts)
+	stationNames := make([][]byte, 0, stations)
+	stationSymbolMap := make(map[string]uint64, stations)
+
+	var (
+		id    uint64
+		line  []byte
+		arena nameArena
+	)
+	// by starts at the beginning of the file
+	by = bytes.TrimPrefix(by, utf8BOM)
+	if opts.SkipHeader {
+		_, by = cutLine(by)
+	}
+	for len(by) > 0 {
+		line, by = cutLine(by)
+
+		name, _, ok := opts.splitLine(line)
+		if !ok {
+			continue
+		}
+
+		key := opts.stationKey(name)
+		if _, ok := stationSymbolMap[string(key)]; !ok {
+			// copy the name, by may be a mapping that is gone before the output is printed
+			stationNames = append(stationNames, arena.clone(name))
+			stationSymbolMap[string(key)] = id
+			id++
 		}
 	}
 
 	return stationNames, stationSymbolMap
 }
 
-// input: string containing signed number in the range [-99.9, 99.9]
-// output: signed int in the range [-999, 999]
-func customStringToIntParser(input []byte) (output int64) {
-	var isNegativeNumber bool
-	if input[0] == '-' {
-		isNegativeNumber = true
-		input = input[1:]
+// cutSign strips a leading '-' or '+' off a temperature and reports
+// whether it was a '-'
+func cutSign(input []byte) ([]byte, bool) {
+	if len(input) > 0 && (input[0] == '-' || input[0] == '+') {
+		return input[1:], input[0] == '-'
 	}
+	return input, false
+}
+
+// input: string containing signed number in the range [-99.9, 99.9]
+// output: signed int in the range [-999, 999], ok is false if input is not
+// a number with exactly one fractional digit
+func customStringToIntParser(input []byte) (output int64, ok bool) {
+	input, isNegativeNumber := cutSign(input)
 
 	switch len(input) {
 	case 3:
 		// 1.2 -> 12
+		if !isDigit(input[0]) || input[1] != '.' || !isDigit(input[2]) {
+			return 0, false
+		}
 		output = int64(input[0])*10 + int64(input[2]) - '0'*11
 	case 4:
 		// 11.2 -> 112
+		if !isDigit(input[0]) || !isDigit(input[1]) || input[2] != '.' || !isDigit(input[3]) {
+			return 0, false
+		}
 		output = int64(input[0])*100 + int64(input[1])*10 + int64(input[3]) - '0'*111
+	default:
+		return 0, false
 	}
 
 	if isNegativeNumber {
-		return -output
+		return -output, true
 	}
-	return
+	return output, true
 }
 
-func evaluateMmap(fileName string, _ int, _ int, printResult bool) error {
-	var (
-		workerResults    = WorkerResults{}
-		stationNames     = make([][]byte, 0, numberOfMaxStations)
-		stationResults   = [numberOfMaxStations]cityTemperatureInfo{}
-		stationSymbolMap = make(map[uint64]uint64, numberOfMaxStations)
-	)
+// wideStringToIntParser is customStringToIntParser for values outside the
+// spec. input: signed number with up to 4 integer digits, [-9999.9, 9999.9]
+// output: signed int in the range [-99999, 99999]
+func wideStringToIntParser(input []byte) (output int64, ok bool) {
+	input, isNegativeNumber := cutSign(input)
 
-	f, err := os.Open(fileName)
-	if err != nil {
-		panic(err)
+	// 1013.2 -> 10132
+	dot := len(input) - 2
+	if dot < 1 || dot > 4 || input[dot] != '.' || !isDigit(input[dot+1]) {
+		return 0, false
 	}
-	defer f.Close()
-
-	stat, _ := f.Stat()
-	size := stat.Size()
+	for _, c := range input[:dot] {
+		if !isDigit(c) {
+			return 0, false
+		}
+		output = output*10 + int64(c-'0')
+	}
+	output = output*10 + int64(input[dot+1]-'0')
 
-	data, err := syscall.Mmap(int(f.Fd()), 0, int(size), syscall.PROT_READ, syscall.MAP_SHARED)
-	if err != nil {
-		panic(err)
+	if isNegativeNumber {
+		return -output, true
 	}
-	defer syscall.Munmap(data)
+	return output, true
+}
 
-	var (
-		id        uint64
-		pos       int
-		off       int
-		stationID uint64
-	)
+// integerStringToIntParser parses a temperature in whole degrees, like -12,
+// into tenths of a degree. It is the parser for inputs without decimals,
+// which skips all '.' handling: up to two digits, or four with wide.
+func integerStringToIntParser(input []byte, wide bool) (output int64, ok bool) {
+	input, isNegativeNumber := cutSign(input)
 
-	// get all station names, assume all station are in the first 5_000_000 lines
-	for pos <= 5_000_000 {
-		for j, c := range data[pos:] {
-			if c == ';' {
-				off = j
-				break
+	switch len(input) {
+	case 1:
+		// 7 -> 70
+		if !isDigit(input[0]) {
+			return 0, false
+		}
+		output = int64(input[0]-'0') * 10
+	case 2:
+		// 12 -> 120
+		if !isDigit(input[0]) || !isDigit(input[1]) {
+			return 0, false
+		}
+		output = (int64(input[0])*10 + int64(input[1]) - '0'*11) * 10
+	case 3, 4:
+		if !wide {
+			return 0, false
+		}
+		for _, c := range input {
+			if !isDigit(c) {
+				return 0, false
 			}
+			output = output*10 + int64(c-'0')
 		}
+		output *= 10
+	default:
+		return 0, false
+	}
 
-		stationID = maphash.Bytes(maphashSeed, data[pos:pos+off])
-		if _, ok := stationSymbolMap[stationID]; !ok {
-			stationNames = append(stationNames, data[pos:pos+off])
-			stationSymbolMap[stationID] = id
-			id++
-		}
+	if isNegativeNumber {
+		return -output, true
+	}
+	return output, true
+}
 
-		pos += off + 2
+// scaledIntParser parses a temperature written as an integer count of
+// 1/scale degrees, like 123 for 12.3 with scale 10, into tenths of a degree.
+// Anything finer than a tenth is rounded, halves toward positive infinity.
+func scaledIntParser(input []byte, scale int64) (output int64, ok bool) {
+	input, isNegativeNumber := cutSign(input)
 
-		if data[pos+2] == '.' {
-			// -21.3\n
-			pos += 5
-		} else if data[pos+1] == '.' {
-			// 21.3\n or -1.3\n
-			pos += 4
-		} else if data[pos] == '.' {
-			// 1.3\n
-			pos += 3
+	// nine digits keep the arithmetic below far from overflowing
+	if len(input) == 0 || len(input) > 9 {
+		return 0, false
+	}
+	for _, c := range input {
+		if !isDigit(c) {
+			return 0, false
 		}
+		output = output*10 + int64(c-'0')
+	}
+	if isNegativeNumber {
+		output = -output
 	}
 
-	workerSize := len(data) / workerCount
+	// floor((output*10/scale) + 1/2), with a floor division that rounds
+	// down for negative values as well
+	numerator, denominator := output*20+scale, 2*scale
+	tenths := numerator / denominator
+	if numerator%denominator < 0 {
+		tenths--
+	}
+	return tenths, true
+}
 
-	done := make(chan struct{}, workerCount)
+// cutLine splits off the first line of data, without its '\n'
+func cutLine(data []byte) (line, rest []byte) {
+	if i := bytes.IndexByte(data, '\n'); i >= 0 {
+		return data[:i], data[i+1:]
+	}
+	return data, nil
+}
 
-	go func() {
-		// sort station names
-		slices.SortFunc(stationNames, func(a, b []byte) int {
-			return bytes.Compare(a, b)
-		})
+// trimSpace strips leading and trailing ASCII whitespace
+func trimSpace(field []byte) []byte {
+	return bytes.Trim(field, " \t\r\v\f")
+}
 
-		done <- struct{}{}
-	}()
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
 
-	for workerID := 0; workerID < workerCount; workerID++ {
-		// process data in parallel
-		go func(workerID int, data []byte) {
-			last := workerSize*(workerID+1) + 20
-			if last > len(data) {
-				last = len(data) - 1
+// splitAtNewlines cuts data into n parts of roughly equal size. Every part
+// but the last ends right after a '\n', so no line is split between parts.
+func splitAtNewlines(data []byte, n int) [][]byte {
+	parts := make([][]byte, n)
+	partSize := len(data) / n
+
+	var start int
+	for i := 0; i < n-1; i++ {
+		end := max(start, partSize*(i+1))
+		if end < len(data) {
+			if j := bytes.IndexByte(data[end:], '\n'); j >= 0 {
+				end += j + 1
+			} else {
+				end = len(data)
 			}
+		} else {
+			end = len(data)
+		}
 
-			data = data[workerSize*workerID : last]
-			data = data[bytes.IndexByte(data, '\n')+1 : bytes.LastIndexByte(data, '\n')+1]
+		parts[i] = data[start:end]
+		start = end
+	}
+	parts[n-1] = data[start:]
 
-			var (
-				pos         int
-				off         int
-				stationID   uint64
-				temperature int64
-			)
+	return parts
+}
 
-			for {
-				// find semicolon to get station name
-				off = -1
+// evaluateMmap is the mmap engine: it maps the file and aggregates it with
+// aggregateData
+func evaluateMmap(fileName string, opts Options) (*aggregation, error) {
+	workers := opts.Workers
+	if workers == 0 {
+		workers = workerCount
+	}
+	f, err := os.Open(fileName)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
 
-				for j, c := range data[pos:] {
-					if c == ';' {
-						off = j
-						break
-					}
-				}
+	stat, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	size := stat.Size()
+	if opts.Sample > 0 {
+		// a sample is small, so a single worker aggregates it
+		workers = 1
+		if size, err = sampleSize(f, size, &opts); err != nil {
+			return nil, err
+		}
+	}
 
-				if off == -1 {
-					break
-				}
+	if size == 0 {
+		// mmap rejects empty mappings
+		return &aggregation{stationSymbolMap: map[string]uint64{}, results: cityMap{}}, nil
+	}
 
-				// translate station name to station ID
-				stationID = stationSymbolMap[maphash.Bytes(maphashSeed, data[pos:pos+off])]
-				pos += off + 1
+	data, err := syscall.Mmap(int(f.Fd()), 0, int(size), syscall.PROT_READ, syscall.MAP_SHARED)
+	if errors.Is(err, syscall.ENOMEM) {
+		// not enough address space for the whole file, map it piecewise
+		opts.logger().Warn("file too large to map at once, mapping it in windows", "file", fileName, "size", size, "window", mmapWindowSize)
+		if size, err = sizeWithoutIncomplete(f, size, &opts); err != nil {
+			return nil, err
+		}
+		return evaluateMmapSegments(f, size, mmapWindowSize, opts)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s: %w", ErrMmap, fileName, err)
+	}
+	defer syscall.Munmap(data)
 
-				// parse temperature
-				{
-					negative := data[pos] == '-'
-					if negative {
-						pos++
-					}
+	// the checksum still covers a dropped last line
+	lines := dropIncomplete(data, &opts)
+	if opts.Checksum == "" {
+		return aggregateData(lines, workers, opts)
+	}
+	// hash the mapped bytes alongside the workers
+	sum := make(chan []byte, 1)
+	go func() {
+		h := newChecksum(opts.Checksum)
+		h.Write(data)
+		sum <- h.Sum(nil)
+	}()
+	agg, err := aggregateData(lines, workers, opts)
+	if agg != nil {
+		agg.checksum = <-sum
+	}
+	return agg, err
+}
 
-					if data[pos+1] == '.' {
-						// 1.2\n
-						temperature = int64(data[pos+2]) + int64(data[pos+0])*10 - '0'*(11)
-						pos += 4
-					} else {
-						// 12.3\n
-						temperature = int64(data[pos+3]) + int64(data[pos+1])*10 + int64(data[pos+0])*100 - '0'*(111)
-						pos += 5
-					}
+// aggregateData aggregates data in parallel, splitting it into parts at
+// line boundaries. A worker that panics fails the run once the other
+// workers are done, unless opts.BestEffort is set: then the results of the
+// other workers are returned along with the error.
+func aggregateData(data []byte, workers int, opts Options) (*aggregation, error) {
+	if opts.RowsPerWorker > 0 {
+		workers, opts.Parts = rowWorkers(data, opts.RowsPerWorker)
+		opts.logger().Info("sizing workers by estimated rows", "rowsPerWorker", opts.RowsPerWorker, "workers", workers, "parts", opts.Parts)
+	}
+	workerResults := make(WorkerResults, workers)
+	stationNames, stationSymbolMap := discoverStations(data, &opts)
 
-					if negative {
-						temperature = -temperature
-					}
-				}
+	sorted := make(chan struct{})
 
-				workerResults[workerID][stationID].count++
-				workerResults[workerID][stationID].sum += temperature
-				if temperature < workerResults[workerID][stationID].min {
-					workerResults[workerID][stationID].min = temperature
-				}
-				if temperature > workerResults[workerID][stationID].max {
-					workerResults[workerID][stationID].max = temperature
-				}
-			}
+	go func() {
+		sortStationNames(stationNames)
+		close(sorted)
+	}()
 
-			done <- struct{}{}
-		}(workerID, data)
-	}
+	// idle workers take the next part until none are left, so with more
+	// parts than workers a worker stuck on a slow part holds up less
+	parts := splitAtNewlines(data, max(workers, opts.Parts))
+	partReports := make([]chunkReport, len(parts))
+	var nextPart atomic.Int64
 
-	// wait for all workers to finish
-	for i := 0; i <= workerCount; i++ {
-		<-done
-	}
+	workerErrors := make([]error, workers)
+	wg := sync.WaitGroup{}
+	for workerID := 0; workerID < workers; workerID++ {
+		// process data in parallel
+		wg.Add(1)
+		go func(workerID int) {
+			defer wg.Done()
 
-	// merge workerResults
-	for _, result := range workerResults {
-		for stationID, stationResult := range result {
-			if stationResult.count == 0 {
-				continue
-			}
+			var taken []int // parts this worker aggregated, or was on
+			defer func() {
+				if r := recover(); r != nil {
+					// the part cut short by the panic can't be told apart
+					// from the finished ones, so drop all of them
+					workerResults[workerID] = workerResult{}
+					for _, i := range taken {
+						partReports[i] = chunkReport{seq: i, dropped: countLines(parts[i])}
+					}
+					workerErrors[workerID] = fmt.Errorf("%w: worker %d, dropped %d of %d parts: %v", ErrWorkerPanicked, workerID, len(taken), len(parts), r)
+				}
+			}()
 
-			stationResults[stationID].sum += stationResult.sum
-			stationResults[stationID].count += stationResult.count
-			if stationResult.min < stationResults[stationID].min {
-				stationResults[stationID].min = stationResult.min
-			}
-			if stationResult.max > stationResults[stationID].max {
-				stationResults[stationID].max = stationResult.max
+			for {
+				i := int(nextPart.Add(1) - 1)
+				if i >= len(parts) {
+					return
+				}
+				taken = append(taken, i)
+				partReports[i] = aggregatePart(parts[i], i, stationSymbolMap, &workerResults[workerID], &opts)
+				if workerResults[workerID].err != nil {
+					// leave no parts for the other workers either
+					nextPart.Store(int64(len(parts)))
+					return
+				}
 			}
-		}
+		}(workerID)
 	}
 
-	var result cityTemperatureInfo
-
-	buf := make([]byte, 0, 50000)
-	buf = append(buf, '{')
-
-	// Print workerResults {station1=min/avg/max, station2=min/avg/max, ...}
-	for i, station := range stationNames {
-		if i != 0 {
-			buf = append(buf, ',', ' ')
-		}
-
-		result = stationResults[stationSymbolMap[maphash.Bytes(maphashSeed, station)]]
+	// wait for all workers and the sort to finish
+	wg.Wait()
+	<-sorted
 
-		buf = append(buf, station...)
-		buf = append(buf, '=')
-		buf = append(buf, strconv.FormatFloat(float64(result.min)/10, 'f', 1, 64)...)
-		buf = append(buf, '/')
-		buf = append(buf, strconv.FormatFloat(float64(result.sum)/(float64(result.count)*10), 'f', 1, 64)...)
-		buf = append(buf, '/')
-		buf = append(buf, strconv.FormatFloat(float64(result.max)/10, 'f', 1, 64)...)
+	workerErr := errors.Join(workerErrors...)
+	if workerErr != nil && !opts.BestEffort {
+		return nil, workerErr
 	}
 
-	buf = append(buf, '}', '\n')
-	if printResult {
-		_, _ = os.Stdout.Write(buf)
+	// merge workerResults
+	agg, err := finishAggregation(workerResults, partReports, stationNames, stationSymbolMap, &opts)
+	if err != nil {
+		return nil, err
 	}
-	return nil
+	return agg, workerErr
 }
+
+// aggregatePart is the aggregateChunk of the mmap workers, replaced by
+// tests to make a worker fail
+var aggregatePart = aggregateChunk
@@ -0,0 +1,1439 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"maps"
+	"math"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"runtime/debug"
+	"slices"
+	"strings"
+	"sync"
+	"syscall"
+	"testing"
+)
+
+type testEngine struct {
+	name     string
+	evaluate func(fileName string, opts Options) (*aggregation, error)
+}
+
+// engines runs every test against the streaming, the mmap and the pread
+// engine
+var engines = []testEngine{
+	{"read", func(fileName string, opts Options) (*aggregation, error) {
+		return evaluate(fileName, 10, 1024*1024, opts)
+	}},
+	{"mmap", evaluateMmap},
+	{"pread", evaluatePread},
+}
+
+func writeFixture(t *testing.T, content string) string {
+	t.Helper()
+	fileName := filepath.Join(t.TempDir(), "measurements.txt")
+	if err := os.WriteFile(fileName, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return fileName
+}
+
+const fixture = `Hamburg;12.0
+Bulawayo;8.9
+Palembang;38.8
+Hamburg;34.2
+St. John's;15.2
+Cracow;12.6
+Bridgetown;26.9
+Istanbul;6.2
+Roseau;34.4
+Conakry;31.2
+Istanbul;23.0
+Hamburg;-5.3
+`
+
+const fixtureResult = "{Bridgetown=26.9/26.9/26.9, Bulawayo=8.9/8.9/8.9, Conakry=31.2/31.2/31.2, " +
+	"Cracow=12.6/12.6/12.6, Hamburg=-5.3/13.6/34.2, Istanbul=6.2/14.6/23.0, Palembang=38.8/38.8/38.8, " +
+	"Roseau=34.4/34.4/34.4, St. John's=15.2/15.2/15.2}\n"
+
+func TestEvaluate(t *testing.T) {
+	fileName := writeFixture(t, fixture)
+
+	for _, engine := range engines {
+		t.Run(engine.name, func(t *testing.T) {
+			agg, err := engine.evaluate(fileName, Options{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := string(appendResults(nil, agg, &Options{})); got != fixtureResult {
+				t.Errorf("got  %s\nwant %s", got, fixtureResult)
+			}
+		})
+	}
+}
+
+func TestReport(t *testing.T) {
+	// the first 64 bytes name every station, so the small chunk run below
+	// discovers all of them from its first chunk
+	fileName := writeFixture(t, `Hamburg;12.0
+Istanbul;6.2
+Cracow;12.x
+Bulawayo;8.9
+no delimiter
+Hamburg;34.2
+Cracow;12.6
+
+Istanbul;
+Hamburg;-5.3
+`)
+
+	want := Report{
+		TotalLines:   10,
+		ParsedLines:  6,
+		SkippedLines: 4,
+		Malformed: []MalformedLine{
+			{Line: 3, Content: "Cracow;12.x"},
+			{Line: 5, Content: "no delimiter"},
+			{Line: 8, Content: ""},
+			{Line: 9, Content: "Istanbul;"},
+		},
+	}
+	wantResult := "{Bulawayo=8.9/8.9/8.9, Cracow=12.6/12.6/12.6, Hamburg=-5.3/13.6/34.2, Istanbul=6.2/6.2/6.2}\n"
+
+	smallChunks := func(fileName string, opts Options) (*aggregation, error) {
+		return evaluate(fileName, 10, 64, opts)
+	}
+
+	for _, engine := range append(engines, testEngine{"read-small-chunks", smallChunks}) {
+		t.Run(engine.name, func(t *testing.T) {
+			agg, err := engine.evaluate(fileName, Options{})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			got := agg.report
+			if got.TotalLines != want.TotalLines || got.ParsedLines != want.ParsedLines || got.SkippedLines != want.SkippedLines {
+				t.Errorf("report counts = %d/%d/%d, want %d/%d/%d",
+					got.TotalLines, got.ParsedLines, got.SkippedLines,
+					want.TotalLines, want.ParsedLines, want.SkippedLines)
+			}
+			if !slices.Equal(got.Malformed, want.Malformed) {
+				t.Errorf("malformed = %+v, want %+v", got.Malformed, want.Malformed)
+			}
+			if result := string(appendResults(nil, agg, &Options{})); result != wantResult {
+				t.Errorf("got  %s\nwant %s", result, wantResult)
+			}
+		})
+	}
+}
+
+func TestFoldCase(t *testing.T) {
+	fileName := writeFixture(t, `Berlin;10.0
+BERLIN;-2.0
+berlin;4.0
+Paris;1.0
+PARIS;3.0
+`)
+
+	for _, engine := range engines {
+		t.Run(engine.name, func(t *testing.T) {
+			opts := Options{FoldCase: true}
+			agg, err := engine.evaluate(fileName, opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			want := "{Berlin=-2.0/4.0/10.0, Paris=1.0/2.0/3.0}\n"
+			if got := string(appendResults(nil, agg, &opts)); got != want {
+				t.Errorf("got  %s\nwant %s", got, want)
+			}
+		})
+	}
+}
+
+func TestTrim(t *testing.T) {
+	fileName := writeFixture(t, "  Berlin ; 12.3 \nBerlin;-1.0\n\tParis\t;\t5.0\r\nParis ;7.0\n")
+
+	for _, engine := range engines {
+		t.Run(engine.name, func(t *testing.T) {
+			opts := Options{Trim: true}
+			agg, err := engine.evaluate(fileName, opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			want := "{Berlin=-1.0/5.7/12.3, Paris=5.0/6.0/7.0}\n"
+			if got := string(appendResults(nil, agg, &opts)); got != want {
+				t.Errorf("got  %s\nwant %s", got, want)
+			}
+			if agg.report.SkippedLines != 0 {
+				t.Errorf("skipped %d lines, want 0", agg.report.SkippedLines)
+			}
+		})
+	}
+}
+
+func TestSplit(t *testing.T) {
+	fileName := writeFixture(t, `Berlin;Mitte;12.0
+Berlin;Mitte;14.0
+Berlin;1.0
+`)
+
+	tests := []struct {
+		name    string
+		opts    Options
+		want    string
+		wantErr string
+	}{
+		{"first", Options{}, "{Berlin=1.0/1.0/1.0}\n", ""},
+		{"first-strict", Options{Strict: true}, "", `malformed line 1: "Berlin;Mitte;12.0"`},
+		{"last", Options{SplitLast: true}, "{Berlin=1.0/1.0/1.0, Berlin;Mitte=12.0/13.0/14.0}\n", ""},
+		{"last-strict", Options{SplitLast: true, Strict: true}, "{Berlin=1.0/1.0/1.0, Berlin;Mitte=12.0/13.0/14.0}\n", ""},
+	}
+
+	for _, engine := range engines {
+		for _, tt := range tests {
+			t.Run(engine.name+"/"+tt.name, func(t *testing.T) {
+				agg, err := engine.evaluate(fileName, tt.opts)
+				if tt.wantErr != "" {
+					if err == nil || err.Error() != tt.wantErr {
+						t.Fatalf("err = %v, want %s", err, tt.wantErr)
+					}
+					return
+				}
+				if err != nil {
+					t.Fatal(err)
+				}
+				if got := string(appendResults(nil, agg, &tt.opts)); got != tt.want {
+					t.Errorf("got  %s\nwant %s", got, tt.want)
+				}
+			})
+		}
+	}
+}
+
+func TestQuoted(t *testing.T) {
+	fileName := writeFixture(t, `"Berlin;Mitte";12.0
+"Berlin;Mitte";14.0
+Berlin;1.0
+"Berlin";3.0
+"Berlin;Mitte;12.0
+"Berlin"x;12.0
+`)
+
+	tests := []struct {
+		name    string
+		opts    Options
+		want    string
+		skipped int64
+	}{
+		{"off", Options{}, "{\"Berlin\"=3.0/3.0/3.0, \"Berlin\"x=12.0/12.0/12.0, Berlin=1.0/1.0/1.0}\n", 3},
+		{"quoted", Options{Quoted: true}, "{Berlin=1.0/2.0/3.0, Berlin;Mitte=12.0/13.0/14.0}\n", 2},
+	}
+
+	for _, engine := range engines {
+		for _, tt := range tests {
+			t.Run(engine.name+"/"+tt.name, func(t *testing.T) {
+				agg, err := engine.evaluate(fileName, tt.opts)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if got := string(appendResults(nil, agg, &tt.opts)); got != tt.want {
+					t.Errorf("got  %s\nwant %s", got, tt.want)
+				}
+				if agg.report.SkippedLines != tt.skipped {
+					t.Errorf("skipped %d lines, want %d", agg.report.SkippedLines, tt.skipped)
+				}
+			})
+		}
+	}
+}
+
+func TestValidateUTF8(t *testing.T) {
+	fileName := writeFixture(t, "Z\xfcrich;1.0\nZürich;2.0\nHamburg;3.0\n")
+
+	for _, engine := range engines {
+		t.Run(engine.name, func(t *testing.T) {
+			agg, err := engine.evaluate(fileName, Options{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if agg.report.InvalidNames != nil {
+				t.Errorf("names checked without ValidateUTF8: %q", agg.report.InvalidNames)
+			}
+
+			agg, err = engine.evaluate(fileName, Options{ValidateUTF8: true})
+			if err != nil {
+				t.Fatal(err)
+			}
+			// still aggregated as a station of its own
+			if got := len(agg.entries(&Options{})); got != 3 {
+				t.Errorf("got %d stations, want 3", got)
+			}
+			if got, want := string(appendReport(nil, agg.report)), "station name is not valid UTF-8: \"Z\\xfcrich\"\n"; got != want {
+				t.Errorf("report %q, want %q", got, want)
+			}
+
+			if _, err := engine.evaluate(fileName, Options{ValidateUTF8: true, Strict: true}); err == nil || !strings.Contains(err.Error(), "not valid UTF-8") {
+				t.Errorf("strict: err = %v", err)
+			}
+		})
+	}
+}
+
+func TestEmptyTemperature(t *testing.T) {
+	fileName := writeFixture(t, "Berlin;\nHamburg;12.0\nBerlin;\n\"Lima\";\nHamburg; \nHamburg;-\nHamburg;-1.0\n")
+
+	for _, engine := range engines {
+		for _, opts := range []Options{{}, {Wide: true}, {Trim: true}, {Quoted: true}} {
+			t.Run(fmt.Sprintf("%s/%+v", engine.name, opts), func(t *testing.T) {
+				agg, err := engine.evaluate(fileName, opts)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if got, want := string(appendResults(nil, agg, &opts)), "{Hamburg=-1.0/5.5/12.0}\n"; got != want {
+					t.Errorf("got  %s\nwant %s", got, want)
+				}
+				if agg.report.SkippedLines != 5 || agg.report.ParsedLines != 2 {
+					t.Errorf("skipped %d and parsed %d lines, want 5 and 2", agg.report.SkippedLines, agg.report.ParsedLines)
+				}
+
+				opts.Strict = true
+				if _, err := engine.evaluate(fileName, opts); err == nil || !strings.HasPrefix(err.Error(), "malformed line 1:") {
+					t.Errorf("strict run: err = %v, want line 1 reported", err)
+				}
+			})
+		}
+	}
+}
+
+func TestDropIncomplete(t *testing.T) {
+	for _, tc := range []struct {
+		tail    string
+		dropped bool
+	}{
+		{"Hamburg;1", true},
+		{"Hamb", true},
+		{"Hamburg;1.5", false}, // a whole record, just without a newline
+	} {
+		content := fixture + tc.tail
+		want, err := evaluateMmap(writeFixture(t, fixture), Options{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !tc.dropped {
+			want, err = evaluateMmap(writeFixture(t, content+"\n"), Options{})
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		fileName := writeFixture(t, content)
+		opts := Options{DropIncomplete: true}
+		for _, engine := range engines {
+			agg, err := engine.evaluate(fileName, opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if agg.report.SkippedLines != 0 {
+				t.Errorf("%s %q: skipped %d lines", engine.name, tc.tail, agg.report.SkippedLines)
+			}
+			if got, want := string(appendResults(nil, agg, &opts)), string(appendResults(nil, want, &opts)); got != want {
+				t.Errorf("%s %q: got  %s\nwant %s", engine.name, tc.tail, got, want)
+			}
+
+			if tc.dropped {
+				strict := Options{DropIncomplete: true, Strict: true}
+				if _, err := engine.evaluate(fileName, strict); err == nil {
+					t.Errorf("%s %q: strict run didn't fail", engine.name, tc.tail)
+				}
+			}
+		}
+
+		stats, err := AggregateBytes([]byte(content), opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !maps.Equal(stats, want.stats(&opts)) {
+			t.Errorf("AggregateBytes %q: got %v, want %v", tc.tail, stats, want.stats(&opts))
+		}
+	}
+}
+
+func TestChunkSizes(t *testing.T) {
+	fileName := writeFixture(t, fixture)
+
+	for _, chunkSize := range []int{maxLineLength + 1, 256, 1024, 1024 * 1024} {
+		agg, err := evaluate(fileName, 10, chunkSize, Options{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := string(appendResults(nil, agg, &Options{})); got != fixtureResult {
+			t.Errorf("chunk size %d: got  %s\nwant %s", chunkSize, got, fixtureResult)
+		}
+		if agg.report.TotalLines != 12 {
+			t.Errorf("chunk size %d: %d lines, want 12", chunkSize, agg.report.TotalLines)
+		}
+	}
+}
+
+func TestParseSize(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    int
+		wantErr bool
+	}{
+		{"65536", 65536, false},
+		{"64K", 64 << 10, false},
+		{"16M", 16 << 20, false},
+		{"16m", 16 << 20, false},
+		{"1G", 1 << 30, false},
+		{"", 0, true},
+		{"M", 0, true},
+		{"-1K", 0, true},
+		{"16MB", 0, true},
+	}
+
+	for _, tt := range tests {
+		got, err := parseSize(tt.in)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("parseSize(%q) = %d, %v; want %d, error %v", tt.in, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestAutoEngineReadsPipes(t *testing.T) {
+	fileName := filepath.Join(t.TempDir(), "measurements.fifo")
+	if err := syscall.Mkfifo(fileName, 0o600); err != nil {
+		t.Skip("named pipes not supported: ", err)
+	}
+
+	go func() {
+		// opening blocks until the engine opens the read end
+		f, err := os.OpenFile(fileName, os.O_WRONLY, 0)
+		if err != nil {
+			return
+		}
+		defer f.Close()
+		_, _ = f.WriteString(fixture)
+	}()
+
+	if engine, err := selectEngine(fileName); err != nil || engine != "stream" {
+		t.Fatalf("selectEngine() = %q, %v; want stream", engine, err)
+	}
+
+	agg, err := run(fileName, "auto", 1024, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := string(appendResults(nil, agg, &Options{})); got != fixtureResult {
+		t.Errorf("got  %s\nwant %s", got, fixtureResult)
+	}
+
+	if engine, err := selectEngine(writeFixture(t, fixture)); err != nil || engine != "mmap" {
+		t.Errorf("selectEngine() on a regular file = %q, %v; want mmap", engine, err)
+	}
+}
+
+func TestWorkerCountDoesNotChangeResults(t *testing.T) {
+	fileName := writeFixture(t, fixture)
+
+	for _, engine := range engines {
+		for _, workers := range []int{1, 2, 3, 8, 16} {
+			agg, err := engine.evaluate(fileName, Options{Workers: workers})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := string(appendResults(nil, agg, &Options{})); got != fixtureResult {
+				t.Errorf("%s with %d workers: got  %s\nwant %s", engine.name, workers, got, fixtureResult)
+			}
+		}
+	}
+}
+
+// TestEvaluateMmapWaitsForWorkers is meant for go test -race: reducing before
+// every worker is done shows up as a race on the worker results, and as
+// missing readings without it
+func TestEvaluateMmapWaitsForWorkers(t *testing.T) {
+	var fixture strings.Builder
+	for i := 0; i < 20_000; i++ {
+		fmt.Fprintf(&fixture, "station-%d;1.0\n", i%97)
+	}
+	fileName := writeFixture(t, fixture.String())
+
+	for _, workers := range []int{1, 2, 7, 16} {
+		agg, err := evaluateMmap(fileName, Options{Workers: workers})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var total int64
+		for _, entry := range agg.entries(&Options{}) {
+			total += entry.result.count
+		}
+		if total != 20_000 {
+			t.Errorf("%d workers: aggregated %d readings, want 20000", workers, total)
+		}
+	}
+}
+
+func TestCountCrossesInt32(t *testing.T) {
+	shard := cityTemperatureInfo{count: math.MaxInt32, min: -10, max: 10, sum: 0}
+
+	var total cityTemperatureInfo
+	total.merge(shard)
+	total.merge(shard)
+	if want := int64(2 * math.MaxInt32); total.count != want {
+		t.Errorf("merged count = %d, want %d", total.count, want)
+	}
+
+	total.add(5)
+	if want := int64(2*math.MaxInt32 + 1); total.count != want {
+		t.Errorf("count after add = %d, want %d", total.count, want)
+	}
+
+	huge := cityTemperatureInfo{count: math.MaxInt64 - 1, min: 0, max: 0}
+	huge.merge(shard)
+	if huge.count != math.MaxInt64 {
+		t.Errorf("count = %d, want it to saturate at %d", huge.count, int64(math.MaxInt64))
+	}
+	huge.add(0)
+	if huge.count != math.MaxInt64 {
+		t.Errorf("count after add = %d, want it to stay at %d", huge.count, int64(math.MaxInt64))
+	}
+}
+
+func TestTrackLines(t *testing.T) {
+	fileName := writeFixture(t, fixture)
+	opts := Options{TrackLines: true}
+
+	want := map[string][2]int64{
+		"Bridgetown": {7, 7},
+		"Bulawayo":   {2, 2},
+		"Conakry":    {10, 10},
+		"Cracow":     {6, 6},
+		"Hamburg":    {1, 12},
+		"Istanbul":   {8, 11},
+		"Palembang":  {3, 3},
+		"Roseau":     {9, 9},
+		"St. John's": {5, 5},
+	}
+
+	smallChunks := testEngine{"read-small-chunks", func(fileName string, opts Options) (*aggregation, error) {
+		return evaluate(fileName, 10, 32, opts)
+	}}
+	for _, engine := range append(slices.Clone(engines), smallChunks) {
+		t.Run(engine.name, func(t *testing.T) {
+			agg, err := engine.evaluate(fileName, opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			stats := agg.stats(&opts)
+			for name, lines := range want {
+				s := stats[name]
+				if got := [2]int64{s.FirstLine, s.LastLine}; got != lines {
+					t.Errorf("%s seen on lines %v, want %v", name, got, lines)
+				}
+			}
+		})
+	}
+
+	agg, err := evaluateMmap(fileName, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s := agg.stats(&Options{})["Hamburg"]; s.FirstLine != 0 || s.LastLine != 0 {
+		t.Errorf("lines tracked without TrackLines: %d-%d", s.FirstLine, s.LastLine)
+	}
+}
+
+func TestTrackExtremes(t *testing.T) {
+	// a repeated maximum keeps the line it was first read on
+	content := fixture + "Hamburg;34.2\nIstanbul;-1.0\n"
+	fileName := writeFixture(t, content)
+	lines := strings.Split(content, "\n")
+	opts := Options{TrackExtremes: true}
+
+	smallChunks := testEngine{"read-small-chunks", func(fileName string, opts Options) (*aggregation, error) {
+		return evaluate(fileName, 10, 32, opts)
+	}}
+	for _, engine := range append(slices.Clone(engines), smallChunks) {
+		t.Run(engine.name, func(t *testing.T) {
+			agg, err := engine.evaluate(fileName, opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			for _, entry := range agg.entries(&opts) {
+				for _, extreme := range []struct {
+					line   int64
+					tenths int64
+				}{{entry.extremes.minLine, entry.result.min}, {entry.extremes.maxLine, entry.result.max}} {
+					want := string(entry.name) + ";" + string(appendTenths(nil, extreme.tenths))
+					if extreme.line < 1 || lines[extreme.line-1] != want {
+						t.Errorf("%s extreme recorded on line %d, want the line %q", entry.name, extreme.line, want)
+					}
+				}
+			}
+			if got := agg.extremes["Hamburg"]; got.minLine != 12 || got.maxLine != 4 {
+				t.Errorf("Hamburg extremes on lines %d and %d, want 12 and 4", got.minLine, got.maxLine)
+			}
+		})
+	}
+
+	agg, err := evaluateMmap(fileName, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts.Format = formatJSON
+	var out bytes.Buffer
+	if err := writeResults(&out, agg, &opts); err != nil {
+		t.Fatal(err)
+	}
+	if want := `{"station":"Hamburg","min":-5.3,"mean":18.8,"max":34.2,"count":4,"min_line":12,"max_line":4}`; !strings.Contains(out.String(), want) {
+		t.Errorf("json output\n%s\nmissing %s", out.String(), want)
+	}
+}
+
+func TestWide(t *testing.T) {
+	fileName := writeFixture(t, "Vostok;-273.1\nVostok;0.0\nPressure;1013.2\nPressure;-0.5\nSpec;12.3\n")
+
+	for _, engine := range engines {
+		t.Run(engine.name, func(t *testing.T) {
+			agg, err := engine.evaluate(fileName, Options{Wide: true})
+			if err != nil {
+				t.Fatal(err)
+			}
+			// -136.55 rounds toward positive infinity
+			want := "{Pressure=-0.5/506.4/1013.2, Spec=12.3/12.3/12.3, Vostok=-273.1/-136.5/0.0}\n"
+			if got := string(appendResults(nil, agg, &Options{})); got != want {
+				t.Errorf("got  %s\nwant %s", got, want)
+			}
+
+			agg, err = engine.evaluate(fileName, Options{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if agg.report.SkippedLines != 2 {
+				t.Errorf("skipped %d lines without -wide, want 2", agg.report.SkippedLines)
+			}
+		})
+	}
+}
+
+func TestScale(t *testing.T) {
+	tests := []struct {
+		scale   int64
+		content string
+		want    string
+	}{
+		{1, "Vostok;-89\nVostok;-1\nLima;21\n", "{Lima=21.0/21.0/21.0, Vostok=-89.0/-45.0/-1.0}\n"},
+		{10, "Vostok;-891\nLima;215\n", "{Lima=21.5/21.5/21.5, Vostok=-89.1/-89.1/-89.1}\n"},
+		// hundredths round to the tenth, halves toward positive infinity
+		{100, "Vostok;-8915\nVostok;-8914\nLima;2150\nLima;2144\nLima;2145\n", "{Lima=21.4/21.5/21.5, Vostok=-89.1/-89.1/-89.1}\n"},
+	}
+
+	for _, engine := range engines {
+		for _, tt := range tests {
+			t.Run(fmt.Sprintf("%s/scale=%d", engine.name, tt.scale), func(t *testing.T) {
+				opts := Options{Scale: tt.scale}
+				agg, err := engine.evaluate(writeFixture(t, tt.content+"Lima;21.5\nLima;\n"), opts)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if got := string(appendResults(nil, agg, &opts)); got != tt.want {
+					t.Errorf("got  %s\nwant %s", got, tt.want)
+				}
+				if agg.report.SkippedLines != 2 {
+					t.Errorf("skipped %d lines, want the decimal and the empty one", agg.report.SkippedLines)
+				}
+			})
+		}
+	}
+}
+
+func TestWideStringToIntParser(t *testing.T) {
+	tests := []struct {
+		input string
+		want  int64
+		ok    bool
+	}{
+		{"0.0", 0, true},
+		{"-273.1", -2731, true},
+		{"1013.2", 10132, true},
+		{"-9999.9", -99999, true},
+		{"12.3", 123, true},
+		{"10000.0", 0, false},
+		{"12", 0, false},
+		{".5", 0, false},
+		{"-", 0, false},
+		{"1a3.2", 0, false},
+		{"12.34", 0, false},
+	}
+	for _, tt := range tests {
+		got, ok := wideStringToIntParser([]byte(tt.input))
+		if got != tt.want || ok != tt.ok {
+			t.Errorf("wideStringToIntParser(%q) = %d, %t, want %d, %t", tt.input, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestPlusSign(t *testing.T) {
+	parsers := []struct {
+		name  string
+		parse func([]byte) (int64, bool)
+	}{
+		{"custom", customStringToIntParser},
+		{"wide", wideStringToIntParser},
+	}
+	for _, parser := range parsers {
+		for _, value := range []string{"0.0", "9.9", "99.9"} {
+			want, _ := parser.parse([]byte(value))
+			got, ok := parser.parse([]byte("+" + value))
+			if got != want || !ok {
+				t.Errorf("%s(+%s) = %d, %t, want %d, true", parser.name, value, got, ok, want)
+			}
+		}
+		for _, value := range []string{"+", "+-1.0", "-+1.0", "++1.0"} {
+			if got, ok := parser.parse([]byte(value)); ok {
+				t.Errorf("%s(%s) = %d, want malformed", parser.name, value, got)
+			}
+		}
+	}
+	if got, ok := integerStringToIntParser([]byte("+12"), false); got != 120 || !ok {
+		t.Errorf("integerStringToIntParser(+12) = %d, %t, want 120, true", got, ok)
+	}
+	if got, ok := scaledIntParser([]byte("+123"), 10); got != 123 || !ok {
+		t.Errorf("scaledIntParser(+123) = %d, %t, want 123, true", got, ok)
+	}
+
+	fileName := writeFixture(t, "Lima;+0.0\nLima;+9.9\nLima;+99.9\nLima;-9.9\n")
+	for _, engine := range engines {
+		t.Run(engine.name, func(t *testing.T) {
+			agg, err := engine.evaluate(fileName, Options{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got, want := string(appendResults(nil, agg, &Options{})), "{Lima=-9.9/25.0/99.9}\n"; got != want {
+				t.Errorf("got  %s\nwant %s", got, want)
+			}
+		})
+	}
+}
+
+func TestNoDecimal(t *testing.T) {
+	fileName := writeFixture(t, "Vostok;-89\nVostok;0\nLima;7\nLima;21\nLima;-0\nLima;21.5\nLima;123\nLima;1-\n")
+
+	for _, engine := range engines {
+		t.Run(engine.name, func(t *testing.T) {
+			opts := Options{NoDecimal: true}
+			agg, err := engine.evaluate(fileName, opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got, want := string(appendResults(nil, agg, &opts)), "{Lima=0.0/9.3/21.0, Vostok=-89.0/-44.5/0.0}\n"; got != want {
+				t.Errorf("got  %s\nwant %s", got, want)
+			}
+			if agg.report.SkippedLines != 3 {
+				t.Errorf("skipped %d lines, want the decimal, the wide and the garbage one", agg.report.SkippedLines)
+			}
+
+			opts.Wide = true
+			agg, err = engine.evaluate(fileName, opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got, want := string(appendResults(nil, agg, &opts)), "{Lima=0.0/37.8/123.0, Vostok=-89.0/-44.5/0.0}\n"; got != want {
+				t.Errorf("wide: got  %s\nwant %s", got, want)
+			}
+		})
+	}
+}
+
+func TestMoreStationsThanTheSpec(t *testing.T) {
+	var content strings.Builder
+	for i := 0; i <= numberOfMaxStations; i++ {
+		fmt.Fprintf(&content, "station-%05d;%d.0\n", i, i%100)
+	}
+	fileName := writeFixture(t, content.String())
+
+	for _, engine := range engines {
+		t.Run(engine.name, func(t *testing.T) {
+			agg, err := engine.evaluate(fileName, Options{})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			stats := agg.stats(&Options{})
+			if len(stats) != numberOfMaxStations+1 {
+				t.Errorf("got %d stations, want %d", len(stats), numberOfMaxStations+1)
+			}
+			if got, want := stats["station-10000"], (Stats{count: 1}); got != want {
+				t.Errorf("station-10000 = %+v, want %+v", got, want)
+			}
+			if got, want := stats["station-09999"], (Stats{count: 1, min: 990, max: 990, sum: 990}); got != want {
+				t.Errorf("station-09999 = %+v, want %+v", got, want)
+			}
+		})
+	}
+}
+
+func TestSeedStations(t *testing.T) {
+	fileName := writeFixture(t, fixture)
+	stations := []string{"Roseau", "Hamburg", "Bulawayo", "Palembang", "St. John's", "Cracow", "Bridgetown", "Istanbul", "Conakry", "Hamburg"}
+
+	for _, engine := range engines {
+		t.Run(engine.name, func(t *testing.T) {
+			passes := discoveryPasses.Load()
+			agg, err := engine.evaluate(fileName, Options{Stations: stations})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := string(appendResults(nil, agg, &Options{})); got != fixtureResult {
+				t.Errorf("got  %s\nwant %s", got, fixtureResult)
+			}
+			if n := discoveryPasses.Load() - passes; n != 0 {
+				t.Errorf("%d discovery passes with a station list", n)
+			}
+
+			// stations missing from the list are malformed
+			agg, err = engine.evaluate(fileName, Options{Stations: stations[2:9]})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if agg.report.SkippedLines != 4 || len(agg.entries(&Options{})) != 7 {
+				t.Errorf("skipped %d lines, kept %d stations; want 4 and 7", agg.report.SkippedLines, len(agg.entries(&Options{})))
+			}
+			if _, err := engine.evaluate(fileName, Options{Stations: stations[2:9], Strict: true}); err == nil {
+				t.Error("strict run accepted a station missing from the list")
+			}
+		})
+	}
+}
+
+func TestMaxStations(t *testing.T) {
+	// station-5 is only seen on a malformed line, so it doesn't count
+	fileName := writeFixture(t, "station-0;1.0\nstation-1;1.0\nstation-2;1.0\nstation-0;2.0\nstation-5;x\n")
+
+	for _, engine := range engines {
+		t.Run(engine.name, func(t *testing.T) {
+			if _, err := engine.evaluate(fileName, Options{MaxStations: 3}); err != nil {
+				t.Errorf("3 stations with MaxStations=3: %v", err)
+			}
+
+			_, err := engine.evaluate(fileName, Options{MaxStations: 2})
+			if want := "found 3 stations, exceeds MaxStations=2; increase with -max-stations"; err == nil || err.Error() != want {
+				t.Errorf("err = %v, want %s", err, want)
+			}
+		})
+	}
+
+	// a worker gives up at the first station too many, not at the end
+	var many strings.Builder
+	for i := 0; i < 5000; i++ {
+		fmt.Fprintf(&many, "station-%d;1.0\n", i)
+	}
+	manyFile := writeFixture(t, many.String())
+	for _, engine := range engines {
+		_, err := engine.evaluate(manyFile, Options{Workers: 1, MaxStations: 10})
+		var stationsErr *TooManyStationsError
+		if !errors.As(err, &stationsErr) || stationsErr.Stations != 11 {
+			t.Errorf("%s: err = %v, want 11 stations found", engine.name, err)
+		}
+	}
+
+	result := workerResult{}
+	aggregateChunk([]byte(many.String()), 0, map[string]uint64{}, &result, &Options{MaxStations: 10})
+	if result.err == nil || len(result.missed) != 11 {
+		t.Errorf("worker kept %d stations, err %v", len(result.missed), result.err)
+	}
+}
+
+func TestQuiet(t *testing.T) {
+	fileName := writeFixture(t, fixture+"garbage\n")
+
+	var logs bytes.Buffer
+	opts := Options{Logger: slog.New(slog.NewTextHandler(&logs, &slog.HandlerOptions{Level: slog.LevelDebug}))}
+	agg, err := run(fileName, "auto", 1024*1024, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(logs.String(), "engine=mmap") {
+		t.Errorf("logger got %q, want the engine picked", logs.String())
+	}
+
+	for _, quiet := range []bool{false, true} {
+		var stdout, stderr bytes.Buffer
+		if err := printResults(&stdout, &stderr, agg, &Options{}, quiet); err != nil {
+			t.Fatal(err)
+		}
+		if stdout.String() != fixtureResult {
+			t.Errorf("quiet=%t: got %q, want %q", quiet, stdout.String(), fixtureResult)
+		}
+		if quiet && stderr.Len() != 0 {
+			t.Errorf("quiet run wrote %q to stderr", stderr.String())
+		}
+		if !quiet && !strings.HasPrefix(stderr.String(), "skipped 1 of 13 lines") {
+			t.Errorf("got report %q", stderr.String())
+		}
+	}
+}
+
+func TestSample(t *testing.T) {
+	var content strings.Builder
+	for i := 0; i < 100_000; i++ {
+		fmt.Fprintf(&content, "station-%d;%d.%d\n", i%37, i%199-99, i%10)
+	}
+	lines := strings.SplitAfter(content.String(), "\n")
+	fileName := writeFixture(t, content.String())
+
+	want, err := evaluateMmap(writeFixture(t, strings.Join(lines[:100], "")), Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantResult := string(appendResults(nil, want, &Options{}))
+
+	opts := Options{Sample: 100}
+	for _, engine := range engines {
+		t.Run(engine.name, func(t *testing.T) {
+			agg, err := engine.evaluate(fileName, opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := string(appendResults(nil, agg, &opts)); got != wantResult {
+				t.Errorf("got  %s\nwant %s", got, wantResult)
+			}
+			if agg.report.TotalLines != 100 {
+				t.Errorf("read %d lines, want 100", agg.report.TotalLines)
+			}
+
+			var stdout, stderr bytes.Buffer
+			if err := printResults(&stdout, &stderr, agg, &opts, false); err != nil {
+				t.Fatal(err)
+			}
+			if got := stderr.String(); got != "results are a sample of the first 100 valid lines\n" {
+				t.Errorf("stderr = %q", got)
+			}
+		})
+	}
+
+	// malformed lines in the sample don't count towards it
+	var mixed strings.Builder
+	for i, line := range lines[:200] {
+		if i%10 == 3 {
+			mixed.WriteString("garbage\n")
+		}
+		mixed.WriteString(line)
+	}
+	mixedFile := writeFixture(t, mixed.String())
+	for _, engine := range engines {
+		agg, err := engine.evaluate(mixedFile, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := string(appendResults(nil, agg, &opts)); got != wantResult {
+			t.Errorf("%s with malformed lines: got  %s\nwant %s", engine.name, got, wantResult)
+		}
+		if agg.report.ParsedLines != 100 || agg.report.SkippedLines != 10 {
+			t.Errorf("%s with malformed lines: %d parsed, %d skipped; want 100, 10", engine.name, agg.report.ParsedLines, agg.report.SkippedLines)
+		}
+	}
+}
+
+func TestTail(t *testing.T) {
+	var content strings.Builder
+	for i := 0; i < 100_000; i++ {
+		fmt.Fprintf(&content, "station-%d;%d.%d\n", i%37, i%199-99, i%10)
+	}
+	lines := strings.SplitAfter(content.String(), "\n")
+	lines = lines[:len(lines)-1]
+
+	for _, newline := range []bool{true, false} {
+		data := content.String()
+		if !newline {
+			data = strings.TrimSuffix(data, "\n")
+		}
+		fileName := writeFixture(t, data)
+
+		// 20,000 lines span several tail windows
+		for _, n := range []int64{1, 100, 20_000, 100_000, 200_000} {
+			want, err := evaluateMmap(writeFixture(t, strings.Join(lines[len(lines)-int(min(n, 100_000)):], "")), Options{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			wantResult := string(appendResults(nil, want, &Options{}))
+
+			opts := Options{Tail: n}
+			// limited stands for -limit-memory, which has to honor it too
+			for _, engine := range []string{"mmap", "stream", "pread", "limited"} {
+				var (
+					agg *aggregation
+					err error
+				)
+				if engine == "limited" {
+					agg, err = evaluateLimited(fileName, opts)
+				} else {
+					agg, err = run(fileName, engine, 0, opts)
+				}
+				if err != nil {
+					t.Fatal(err)
+				}
+				if got := string(appendResults(nil, agg, &opts)); got != wantResult {
+					t.Errorf("newline %t, tail %d, %s: got  %s\nwant %s", newline, n, engine, got, wantResult)
+				}
+				if agg.report.TotalLines != min(n, 100_000) {
+					t.Errorf("newline %t, tail %d, %s: read %d lines", newline, n, engine, agg.report.TotalLines)
+				}
+			}
+		}
+	}
+}
+
+func TestMmapParts(t *testing.T) {
+	fileName := writeFixture(t, "station;temperature\n"+fixture)
+
+	for _, parts := range []int{0, 1, 3, 7, 100} {
+		opts := Options{Workers: 2, Parts: parts, SkipHeader: true, TrackLines: true}
+		agg, err := evaluateMmap(fileName, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := string(appendResults(nil, agg, &opts)); got != fixtureResult {
+			t.Errorf("%d parts: got  %s\nwant %s", parts, got, fixtureResult)
+		}
+		if got := agg.lines["Hamburg"]; got != (lineSpan{first: 2, last: 13}) {
+			t.Errorf("%d parts: Hamburg seen on lines %d-%d, want 2-13", parts, got.first, got.last)
+		}
+	}
+}
+
+func TestRowsPerWorker(t *testing.T) {
+	content := strings.Repeat(fixture, 50)
+	fileName := writeFixture(t, content)
+	base, err := evaluateMmap(fileName, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := string(appendResults(nil, base, &Options{}))
+
+	rows := int64(strings.Count(content, "\n"))
+	for _, rowsPerWorker := range []int64{1, 7, 100, rows, 1e9} {
+		opts := Options{RowsPerWorker: rowsPerWorker}
+		agg, err := evaluateMmap(fileName, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := string(appendResults(nil, agg, &opts)); got != want {
+			t.Errorf("%d rows per worker: got  %s\nwant %s", rowsPerWorker, got, want)
+		}
+	}
+
+	lines := []byte(strings.Repeat("Hamburg;12.0\n", 1000))
+	for _, tc := range []struct {
+		rowsPerWorker  int64
+		workers, parts int
+	}{
+		{1, maxRowWorkers, 1000},
+		{100, 10, 10},
+		{300, 4, 4},
+		{1e9, 1, 1},
+	} {
+		workers, parts := rowWorkers(lines, tc.rowsPerWorker)
+		if workers != tc.workers || parts != tc.parts {
+			t.Errorf("%d rows per worker: %d workers, %d parts, want %d, %d", tc.rowsPerWorker, workers, parts, tc.workers, tc.parts)
+		}
+	}
+}
+
+func TestVersion(t *testing.T) {
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		t.Skip("test binary has no build info")
+	}
+
+	got := string(appendVersion(nil, info))
+	if !strings.Contains(got, info.GoVersion+"\n") {
+		t.Errorf("version output %q is missing the Go version", got)
+	}
+
+	info = &debug.BuildInfo{
+		GoVersion: "go1.24.0",
+		Main:      debug.Module{Path: "github.com/zhehlovvalentyn/1brc", Version: "v1.2.3"},
+		Settings:  []debug.BuildSetting{{Key: "vcs.revision", Value: "abc123"}, {Key: "-ldflags", Value: "-s -w"}},
+	}
+	want := "github.com/zhehlovvalentyn/1brc v1.2.3\ngo1.24.0\nvcs.revision=abc123\n-ldflags=-s -w\n"
+	if got := string(appendVersion(nil, info)); got != want {
+		t.Errorf("got  %q\nwant %q", got, want)
+	}
+}
+
+func TestWorkerPanic(t *testing.T) {
+	content := strings.Repeat(fixture, 100)
+	fileName := writeFixture(t, content)
+	opts := Options{Workers: 4, Parts: 8}
+	parts := splitAtNewlines([]byte(content), opts.Parts)
+
+	// the worker that takes part 3 panics, owners tells which worker that is
+	var mu sync.Mutex
+	owners := map[int]*workerResult{}
+	aggregatePart = func(chunk []byte, seq int, symbols map[string]uint64, result *workerResult, opts *Options) chunkReport {
+		mu.Lock()
+		owners[seq] = result
+		mu.Unlock()
+		if seq == 3 {
+			panic("injected")
+		}
+		return aggregateChunk(chunk, seq, symbols, result, opts)
+	}
+	t.Cleanup(func() { aggregatePart = aggregateChunk })
+
+	if agg, err := evaluateMmap(fileName, opts); err == nil || agg != nil {
+		t.Fatalf("got %v, %v; want only an error", agg, err)
+	}
+
+	opts.BestEffort = true
+	agg, err := evaluateMmap(fileName, opts)
+	if err == nil || !strings.Contains(err.Error(), "injected") {
+		t.Fatalf("err = %v, want the panic reported", err)
+	}
+
+	// the parts of the other workers are all there
+	var kept strings.Builder
+	for i, part := range parts {
+		if owners[i] != owners[3] {
+			kept.Write(part)
+		}
+	}
+	aggregatePart = aggregateChunk
+	want, err := evaluateMmap(writeFixture(t, kept.String()), Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := string(appendResults(nil, agg, &opts)), string(appendResults(nil, want, &opts)); got != want {
+		t.Errorf("got  %s\nwant %s", got, want)
+	}
+	if agg.report.TotalLines != want.report.TotalLines || agg.report.TotalLines == 0 {
+		t.Errorf("kept %d lines, want %d", agg.report.TotalLines, want.report.TotalLines)
+	}
+}
+
+func TestWorkerPanicKeepsLineNumbers(t *testing.T) {
+	var content strings.Builder
+	for i := 0; i < 800; i++ {
+		fmt.Fprintf(&content, "s%d;1.0\n", i)
+	}
+	opts := Options{Workers: 4, Parts: 8, BestEffort: true, TrackLines: true}
+
+	aggregatePart = func(chunk []byte, seq int, symbols map[string]uint64, result *workerResult, opts *Options) chunkReport {
+		if seq == 3 {
+			panic("injected")
+		}
+		return aggregateChunk(chunk, seq, symbols, result, opts)
+	}
+	t.Cleanup(func() { aggregatePart = aggregateChunk })
+
+	agg, err := evaluateMmap(writeFixture(t, content.String()), opts)
+	if err == nil {
+		t.Fatal("no error for the panic")
+	}
+	if len(agg.lines) == 0 || len(agg.lines) == 800 {
+		t.Fatalf("kept %d of 800 stations", len(agg.lines))
+	}
+	// station si is on line i+1 of the file, whatever parts were dropped
+	for key, span := range agg.lines {
+		var i int64
+		if _, err := fmt.Sscanf(key, "s%d", &i); err != nil {
+			t.Fatal(err)
+		}
+		if span != (lineSpan{first: i + 1, last: i + 1}) {
+			t.Errorf("%s seen on lines %d-%d, want %d", key, span.first, span.last, i+1)
+		}
+	}
+}
+
+func TestTrace(t *testing.T) {
+	traceFile := filepath.Join(t.TempDir(), "trace.out")
+	stop, err := startTrace(traceFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := evaluateMmap(writeFixture(t, fixture), Options{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := stop(); err != nil {
+		t.Fatal(err)
+	}
+
+	info, err := os.Stat(traceFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Size() == 0 {
+		t.Error("trace file is empty")
+	}
+}
+
+func TestEvaluateReaderBoundsMemory(t *testing.T) {
+	input := strings.Repeat(fixture, 50_000) // 8 MB
+	opts := Options{Workers: 1}
+
+	var before, after runtime.MemStats
+	runtime.GC()
+	runtime.ReadMemStats(&before)
+	agg, err := evaluateReader(strings.NewReader(input), 1, 4096, opts)
+	runtime.ReadMemStats(&after)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if agg.report.TotalLines != 600_000 {
+		t.Errorf("aggregated %d lines, want 600000", agg.report.TotalLines)
+	}
+	// a buffer per chunk would allocate at least the input size, the pool
+	// only holds four chunks
+	if allocated := after.TotalAlloc - before.TotalAlloc; allocated > uint64(len(input)/2) {
+		t.Errorf("allocated %d bytes for %d bytes of input", allocated, len(input))
+	}
+}
+
+// appendingReader appends extra to the file it reads on the first Read, like
+// a writer still logging to the file while it is aggregated
+type appendingReader struct {
+	r     io.Reader
+	file  string
+	extra string
+	done  bool
+}
+
+func (a *appendingReader) Read(p []byte) (int, error) {
+	if !a.done {
+		a.done = true
+		f, err := os.OpenFile(a.file, os.O_APPEND|os.O_WRONLY, 0)
+		if err != nil {
+			return 0, err
+		}
+		defer f.Close()
+		if _, err := f.WriteString(a.extra); err != nil {
+			return 0, err
+		}
+	}
+	return a.r.Read(p)
+}
+
+func TestGrowingFile(t *testing.T) {
+	want, err := evaluateMmap(writeFixture(t, fixture), Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a whole line and the start of one still being written
+	const extra = "Hamburg;99.9\nHamb"
+	for _, snapshotted := range []bool{false, true} {
+		fileName := writeFixture(t, fixture)
+		f, err := os.Open(fileName)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer f.Close()
+
+		var r io.Reader = f
+		if snapshotted {
+			if r, err = snapshot(f); err != nil {
+				t.Fatal(err)
+			}
+		}
+		// chunks smaller than the file, so reading goes on after the append
+		got, err := evaluateReader(&appendingReader{r: r, file: fileName, extra: extra}, 1, 64, Options{})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if equal := reflect.DeepEqual(got.entries(&Options{}), want.entries(&Options{})); equal != snapshotted {
+			t.Errorf("snapshot %t: results equal to the original file %t", snapshotted, equal)
+		}
+	}
+}
+
+func TestEvaluateLimited(t *testing.T) {
+	content := strings.Repeat(fixture, 40_000) // 6 MB, several chunks
+	fileName := writeFixture(t, content)
+
+	want, err := evaluateMmap(fileName, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var before, after runtime.MemStats
+	runtime.GC()
+	runtime.ReadMemStats(&before)
+	agg, err := evaluateLimited(fileName, Options{})
+	runtime.ReadMemStats(&after)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := string(appendResults(nil, agg, &Options{})), string(appendResults(nil, want, &Options{})); got != want {
+		t.Errorf("got  %s\nwant %s", got, want)
+	}
+	if agg.report.TotalLines != 480_000 {
+		t.Errorf("aggregated %d lines, want 480000", agg.report.TotalLines)
+	}
+	if allocated := after.TotalAlloc - before.TotalAlloc; allocated > limitedBuffers+1<<20 {
+		t.Errorf("allocated %d bytes, want at most the %d of the buffers and some", allocated, limitedBuffers)
+	}
+
+	if _, err := evaluateLimited(writeFixture(t, strings.Repeat("x", 2*limitedChunkSize)+"\n"), Options{}); err == nil {
+		t.Error("a line longer than a chunk didn't fail the run")
+	}
+}
+
+func TestBOM(t *testing.T) {
+	withBOM := writeFixture(t, "\xef\xbb\xbf"+fixture)
+	// only a mark at the very start of the file is skipped
+	midFile := writeFixture(t, fixture+"\xef\xbb\xbfHamburg;1.0\n")
+
+	smallChunks := testEngine{"read-small-chunks", func(fileName string, opts Options) (*aggregation, error) {
+		return evaluate(fileName, 10, 32, opts)
+	}}
+	for _, engine := range append(slices.Clone(engines), smallChunks) {
+		for _, opts := range []Options{{}, {SkipHeader: true}} {
+			t.Run(fmt.Sprintf("%s/%+v", engine.name, opts), func(t *testing.T) {
+				agg, err := engine.evaluate(withBOM, opts)
+				if err != nil {
+					t.Fatal(err)
+				}
+				want, err := engine.evaluate(writeFixture(t, fixture), opts)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if got, want := string(appendResults(nil, agg, &opts)), string(appendResults(nil, want, &opts)); got != want {
+					t.Errorf("got  %s\nwant %s", got, want)
+				}
+				for name := range agg.stationSymbolMap {
+					if strings.HasPrefix(name, "\xef\xbb\xbf") {
+						t.Errorf("station %q registered with the mark", name)
+					}
+				}
+
+				agg, err = engine.evaluate(midFile, opts)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if _, ok := agg.stats(&opts)["\xef\xbb\xbfHamburg"]; !ok {
+					t.Error("mark in the middle of the file was dropped")
+				}
+			})
+		}
+	}
+}
+
+func TestSkipHeader(t *testing.T) {
+	fileName := writeFixture(t, "station;temperature\n"+fixture+"Hamburg;x\n")
+	opts := Options{SkipHeader: true}
+
+	smallChunks := testEngine{"read-small-chunks", func(fileName string, opts Options) (*aggregation, error) {
+		return evaluate(fileName, 10, 64, opts)
+	}}
+	validate := testEngine{"validate", func(fileName string, opts Options) (*aggregation, error) {
+		report, err := validateFile(fileName, opts)
+		return &aggregation{report: report}, err
+	}}
+	for _, engine := range append(slices.Clone(engines), smallChunks, validate) {
+		t.Run(engine.name, func(t *testing.T) {
+			agg, err := engine.evaluate(fileName, opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			want := Report{
+				TotalLines:   14,
+				ParsedLines:  12,
+				SkippedLines: 1,
+				Malformed:    []MalformedLine{{Line: 14, Content: "Hamburg;x"}},
+			}
+			if !reflect.DeepEqual(agg.report, want) {
+				t.Errorf("report = %+v, want %+v", agg.report, want)
+			}
+			if engine.name != "validate" {
+				if got := string(appendResults(nil, agg, &opts)); got != fixtureResult {
+					t.Errorf("got  %s\nwant %s", got, fixtureResult)
+				}
+			}
+		})
+	}
+
+	agg, err := evaluateMmap(fileName, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if agg.report.SkippedLines != 2 || agg.report.Malformed[0].Line != 1 {
+		t.Errorf("without SkipHeader the header isn't malformed: %+v", agg.report)
+	}
+}
+
+func TestSortedInputSkipsSort(t *testing.T) {
+	sortedFixture := "Bridgetown;26.9\nBulawayo;8.9\nHamburg;12.0\nHamburg;-5.3\nIstanbul;6.2\n"
+	want := "{Bridgetown=26.9/26.9/26.9, Bulawayo=8.9/8.9/8.9, Hamburg=-5.3/3.4/12.0, Istanbul=6.2/6.2/6.2}\n"
+
+	for _, engine := range engines {
+		t.Run(engine.name, func(t *testing.T) {
+			before := stationNameSorts.Load()
+			agg, err := engine.evaluate(writeFixture(t, sortedFixture), Options{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := string(appendResults(nil, agg, &Options{})); got != want {
+				t.Errorf("got  %s\nwant %s", got, want)
+			}
+			if sorts := stationNameSorts.Load() - before; sorts != 0 {
+				t.Errorf("sorted already sorted names %d times", sorts)
+			}
+
+			if _, err := engine.evaluate(writeFixture(t, fixture), Options{}); err != nil {
+				t.Fatal(err)
+			}
+			if stationNameSorts.Load() == before {
+				t.Error("unsorted names weren't sorted")
+			}
+		})
+	}
+}
+
+func TestLinesLongerThanTheChunkSize(t *testing.T) {
+	long := strings.Repeat("x", 1500)
+	longer := strings.Repeat("y", 3000)
+	content := "a;1.0\n" + long + ";2.0\n" + longer + ";3.0\n" + long + ";4.0\nb;5.0\n"
+	opts := Options{MaxNameLength: 4000}
+	want := fmt.Sprintf("{a=1.0/1.0/1.0, b=5.0/5.0/5.0, %s=2.0/3.0/4.0, %s=3.0/3.0/3.0}\n", long, longer)
+
+	agg, err := evaluateReader(strings.NewReader(content), 2, 1024, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := string(appendResults(nil, agg, &opts)); got != want {
+		t.Errorf("got  %.100s\nwant %.100s", got, want)
+	}
+
+	opts.MaxLineLength = 2048
+	_, err = evaluateReader(strings.NewReader(content), 2, 1024, opts)
+	if wantErr := "line at byte 1511 is longer than 2048 bytes"; err == nil || err.Error() != wantErr {
+		t.Errorf("got error %v, want %q", err, wantErr)
+	}
+
+	f, err := os.Open(writeFixture(t, content))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	err = preadRange(f, 0, int64(len(content)), int64(len(content)), 1024, 2048, func([]byte) error { return nil })
+	if wantErr := "line at byte 1511 is longer than 2048 bytes"; err == nil || err.Error() != wantErr {
+		t.Errorf("pread: got error %v, want %q", err, wantErr)
+	}
+}
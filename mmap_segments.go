@@ -0,0 +1,100 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"slices"
+	"sync"
+	"syscall"
+)
+
+// mmapWindowSize is how much of the file evaluateMmapSegments maps at once
+const mmapWindowSize = 1 << 30
+
+// evaluateMmapSegments is evaluateMmap for files that can't be mapped in one
+// piece, e.g. on 32-bit targets or under a tight ulimit -v. It maps window
+// bytes at a time and carries the line cut off at the end of a window over
+// to the next one, like evaluate does with leftOver. window must be a
+// multiple of the page size.
+func evaluateMmapSegments(f *os.File, size int64, window int, opts Options) (*aggregation, error) {
+	workers := opts.Workers
+	if workers == 0 {
+		workers = workerCount
+	}
+	var (
+		workerResults    = make(WorkerResults, workers)
+		chunkReports     []chunkReport
+		stationNames     [][]byte
+		stationSymbolMap map[string]uint64
+		leftOver         []byte
+		seq              int
+	)
+
+	for offset := int64(0); offset < size; offset += int64(window) {
+		length := int(min(int64(window), size-offset))
+		lastWindow := offset+int64(length) == size
+
+		data, err := syscall.Mmap(int(f.Fd()), offset, length, syscall.PROT_READ, syscall.MAP_SHARED)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s: %w", ErrMmap, f.Name(), err)
+		}
+
+		if offset == 0 {
+			stationNames, stationSymbolMap = discoverStations(data, &opts)
+		}
+
+		body := data
+		if len(leftOver) > 0 {
+			// finish the line started in the previous window
+			end := bytes.IndexByte(body, '\n') + 1
+			if end == 0 && !lastWindow {
+				// the whole window is the middle of one line
+				leftOver = append(leftOver, body...)
+				_ = syscall.Munmap(data)
+				continue
+			}
+			if end == 0 {
+				end = len(body)
+			}
+
+			line := append(leftOver, body[:end]...)
+			chunkReports = append(chunkReports, aggregateChunk(line, seq, stationSymbolMap, &workerResults[0], &opts))
+			seq++
+
+			leftOver = leftOver[:0]
+			body = body[end:]
+		}
+
+		if !lastWindow {
+			end := bytes.LastIndexByte(body, '\n') + 1
+			leftOver = append(leftOver, body[end:]...)
+			body = body[:end]
+		}
+
+		reports := make([]chunkReport, workers)
+		wg := sync.WaitGroup{}
+		for workerID, part := range splitAtNewlines(body, workers) {
+			wg.Add(1)
+			go func(workerID int, part []byte) {
+				defer wg.Done()
+				reports[workerID] = aggregateChunk(part, seq+workerID, stationSymbolMap, &workerResults[workerID], &opts)
+			}(workerID, part)
+		}
+		wg.Wait()
+
+		chunkReports = append(chunkReports, reports...)
+		seq += workers
+
+		if err := syscall.Munmap(data); err != nil {
+			return nil, err
+		}
+		if slices.ContainsFunc(workerResults, func(r workerResult) bool { return r.err != nil }) {
+			// finishAggregation returns the error, the other windows
+			// needn't be read
+			break
+		}
+	}
+
+	return finishAggregation(workerResults, chunkReports, stationNames, stationSymbolMap, &opts)
+}
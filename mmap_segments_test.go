@@ -0,0 +1,44 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestEvaluateMmapSegments(t *testing.T) {
+	var fixture strings.Builder
+	for i := 0; fixture.Len() < 10*os.Getpagesize(); i++ {
+		// names of varying length so lines straddle window boundaries at
+		// different positions
+		fmt.Fprintf(&fixture, "%s;%d.%d\n", strings.Repeat("x", i%23+1), i%100-50, i%10)
+	}
+	fileName := writeFixture(t, fixture.String())
+
+	opts := Options{}
+	want, err := evaluateMmap(fileName, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := os.Open(fileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	for _, window := range []int{os.Getpagesize(), 3 * os.Getpagesize()} {
+		got, err := evaluateMmapSegments(f, int64(fixture.Len()), window, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if g, w := string(appendResults(nil, got, &opts)), string(appendResults(nil, want, &opts)); g != w {
+			t.Errorf("window %d: got  %s\nwant %s", window, g, w)
+		}
+		if got.report.TotalLines != want.report.TotalLines || got.report.SkippedLines != 0 {
+			t.Errorf("window %d: report %+v, want %d clean lines", window, got.report, want.report.TotalLines)
+		}
+	}
+}
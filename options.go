@@ -0,0 +1,321 @@
+package main
+
+import (
+	"bytes"
+	"log/slog"
+)
+
+// defaultMaxNameLength is the longest station name the 1BRC spec allows
+const defaultMaxNameLength = 100
+
+// Options tunes how measurement lines are interpreted and how the results
+// are printed. The zero value is the plain 1BRC format.
+type Options struct {
+	// FoldCase groups station names that differ only in letter case. The
+	// first spelling seen in the file is the one printed.
+	FoldCase bool
+
+	// Trim strips ASCII whitespace around the station name and the
+	// temperature. Off by default to keep the hot loop lean.
+	Trim bool
+
+	// Strict fails the run on the first malformed line instead of skipping
+	// it and counting it in the Report.
+	Strict bool
+
+	// ValidateUTF8 reports station names that aren't valid UTF-8 in the
+	// Report, or with Strict fails the run on them. Names are opaque bytes
+	// to the aggregation either way.
+	ValidateUTF8 bool
+
+	// SkipHeader ignores the first line of the input, for files that start
+	// with a header such as station;temperature
+	SkipHeader bool
+
+	// SplitLast splits a line at its last delimiter, so station names may
+	// contain ';'. By default the first delimiter ends the name and a line
+	// with more than one delimiter is malformed.
+	SplitLast bool
+
+	// Quoted lets station names be wrapped in double quotes, like
+	// "Berlin;Mitte";12.3, so they may contain ';'. The quotes are not part
+	// of the name. Unquoted names are split as usual.
+	Quoted bool
+
+	// Format is the output format: text (the default), lines, range, json,
+	// jsonl, csv or binary
+	Format string
+
+	// Wide accepts temperatures with up to four integer digits, like -273.1
+	// or 1013.2, instead of the [-99.9, 99.9] of the spec
+	Wide bool
+
+	// WideSum sums the readings of every station in 128 bits instead of 64.
+	// 64 bits hold the sum of 9*10^15 readings of 99.9, or 9*10^13 of the
+	// 9999.9 Wide allows, so it is only needed for inputs that large. It
+	// costs a check per line.
+	WideSum bool
+
+	// Scale reads temperatures as integers counting 1/Scale degrees, so 123
+	// is 12.3 with a Scale of 10 and 1.23 with 100, rounded to a tenth. Zero
+	// reads decimals like 12.3.
+	Scale int64
+
+	// NoDecimal reads temperatures as whole degrees without a decimal point,
+	// like -12, with a parser that skips all '.' handling. Values with a
+	// decimal point are malformed then. Scale takes precedence.
+	NoDecimal bool
+
+	// Exact prints the average with full float precision instead of
+	// rounding it to one decimal, and adds the sum of all readings to the
+	// json and csv formats so partial results can be merged exactly
+	Exact bool
+
+	// Histogram counts the readings of every station per tenth of a degree
+	// and prints those counts instead of the results
+	Histogram bool
+
+	// HistogramBucket is the width of the histogram buckets in tenths of a
+	// degree. Zero means a bucket per tenth.
+	HistogramBucket int64
+
+	// NoNewline leaves out the newline that otherwise ends the output of
+	// every format but binary
+	NoNewline bool
+
+	// Precision is the number of decimals printed for min, mean and max,
+	// which round halves toward positive infinity. Zero means the one
+	// decimal of the spec, a negative value whole degrees.
+	Precision int
+
+	// Exclude names stations left out of the output. They are aggregated
+	// all the same, so the summary still covers them.
+	Exclude []string
+
+	// Top prints only the Top stations with the highest TopBy, highest
+	// first, ties in name order. The summary still covers all stations.
+	// Zero prints all of them.
+	Top int
+
+	// TopBy is the metric Top ranks by: min, mean, max (the default) or
+	// count
+	TopBy string
+
+	// Sort orders the output by name (the default, as the spec wants it),
+	// min, max, avg or count, with ties in name order. It applies after Top,
+	// so it reorders the stations Top picked.
+	Sort string
+
+	// Order is asc (the default) or desc, the direction of Sort
+	Order string
+
+	// Collate sorts the output by the Unicode collation of this language
+	// tag, like en or sv, so accented names sort where readers expect them.
+	// Empty sorts by bytes, as the spec does, which is faster. Collation
+	// needs a build with -tags collate, without it the run fails.
+	Collate string
+
+	// Summary adds an ALL=min/avg/max line over all stations to the output
+	Summary bool
+
+	// HashSeed seeded the hash stations were keyed by.
+	//
+	// Deprecated: stations are keyed by name, so there is nothing left to
+	// seed and HashSeed is ignored.
+	HashSeed uint64
+
+	// WithCount adds the number of readings to the text, lines and range
+	// formats, as station=min/avg/max/count or station=min..max/count. The
+	// json and csv formats always have it.
+	WithCount bool
+
+	// Checksum hashes the input file with crc32 or sha256 while it is
+	// aggregated, so a run can be tied to the exact file it read. The
+	// streaming and mmap engines hash the bytes they read anyway, pread
+	// reads the file once more. Empty means no checksum.
+	Checksum string
+
+	// DropIncomplete ignores a last line that has no newline and doesn't
+	// parse, as a writer killed mid-line leaves it, instead of reporting it
+	// as malformed. A last line that parses is kept. Strict overrides it.
+	DropIncomplete bool
+
+	// BestEffort makes the mmap engine and AggregateBytes return the
+	// results of the healthy workers along with the error when a worker
+	// fails. Everything the failed worker aggregated is left out, and its
+	// lines aren't counted, but line numbers stay those of the file.
+	BestEffort bool
+
+	// Workers is the number of goroutines aggregating in parallel. Zero
+	// keeps each engine's default.
+	Workers int
+
+	// Parts is the number of parts the mmap engine splits the file into.
+	// Idle workers take the next part, so more parts than workers even out
+	// the load when some parts take longer, e.g. with skewed line lengths.
+	// Zero, or fewer than Workers, gives every worker one part.
+	Parts int
+
+	// RowsPerWorker has the mmap engine split the file into parts of about
+	// this many rows, estimated from the average line length, and start a
+	// worker per part instead of Workers, up to maxRowWorkers. It overrides
+	// Workers and Parts. Zero keeps them.
+	RowsPerWorker int64
+
+	// Files is the number of files a multi-file run aggregates at once, each
+	// with Workers workers. Zero runs as many as there are default workers,
+	// and then a zero Workers splits those between the files.
+	Files int
+
+	// MaxStations fails the run if the input has more stations than this,
+	// counting those with at least one valid reading. A worker that sees
+	// one too many stops the run right away, so no table grows much past
+	// the limit. Zero means no limit, the tables grow for any number of
+	// stations.
+	MaxStations int
+
+	// Stations lists every station of the input up front, so the engines
+	// skip discovering them. Lines of other stations are malformed. Nil
+	// discovers the stations.
+	Stations []string
+
+	// MaxNameLength is the longest station name accepted, longer ones make
+	// the line malformed. Zero means the 100 bytes of the spec.
+	MaxNameLength int
+
+	// TrackLines records the first and last line every station is seen on.
+	// It costs a map update per line, so it is off by default.
+	TrackLines bool
+
+	// TrackExtremes records the line of the lowest and highest reading of
+	// every station, the first one on a tie. Off by default for the same
+	// reason as TrackLines.
+	TrackExtremes bool
+
+	// Logger receives diagnostics of a run, like the engine picked or a
+	// fallback taken. Nil discards them.
+	Logger *slog.Logger
+
+	// MaxLineLength caps how far the streaming and pread engines grow their
+	// buffers for a line longer than they are. A longer line fails the run.
+	// Zero means no cap.
+	MaxLineLength int
+
+	// Sample aggregates only the first Sample valid lines of the input.
+	// Malformed lines among them are reported but not counted. Zero means
+	// all of it.
+	Sample int64
+
+	// Tail aggregates only the last Tail lines of a file, seeking back from
+	// the end to find them, so recent data at the end of a long log is read
+	// without the rest. Zero means all of it.
+	Tail int64
+
+	// ChunkSize is the read size of the streaming engine behind
+	// AggregateReader. Zero means defaultChunkSize.
+	ChunkSize int
+}
+
+// logger returns o.Logger, or a logger that discards everything
+func (o *Options) logger() *slog.Logger {
+	if o.Logger == nil {
+		return slog.New(slog.DiscardHandler)
+	}
+	return o.Logger
+}
+
+// precision returns the number of decimals to print
+func (o *Options) precision() int {
+	switch {
+	case o.Precision == 0:
+		return 1
+	case o.Precision < 0:
+		return 0
+	}
+	return o.Precision
+}
+
+// splitLine cuts a line into the station name and the temperature field
+func (o *Options) splitLine(line []byte) (name, value []byte, ok bool) {
+	maxNameLength := o.MaxNameLength
+	if maxNameLength == 0 {
+		maxNameLength = defaultMaxNameLength
+	}
+
+	if o.Quoted && len(line) > 0 && line[0] == '"' {
+		return o.splitQuoted(line, maxNameLength)
+	}
+	if o.SplitLast {
+		separator := bytes.LastIndexByte(line, ';')
+		if separator < 0 {
+			return nil, nil, false
+		}
+		name, value = line[:separator], line[separator+1:]
+	} else {
+		// don't scan further than a name may be long, trimmed whitespace
+		// aside, so garbage without delimiters is rejected quickly
+		limit := maxNameLength + 1
+		if o.Trim {
+			limit = len(line)
+		}
+		separator := bytes.IndexByte(line[:min(len(line), limit)], ';')
+		if separator < 0 {
+			return nil, nil, false
+		}
+		name, value = line[:separator], line[separator+1:]
+		if bytes.IndexByte(value, ';') >= 0 {
+			return nil, nil, false
+		}
+	}
+
+	if o.Trim {
+		name, value = trimSpace(name), trimSpace(value)
+	}
+	if len(name) > maxNameLength {
+		return nil, nil, false
+	}
+	return name, value, true
+}
+
+// splitQuoted splits a line that starts with a quoted station name. The
+// name ends at the next quote, which has to be followed by the delimiter.
+func (o *Options) splitQuoted(line []byte, maxNameLength int) (name, value []byte, ok bool) {
+	// like the unquoted scan, don't look further than a name may be long
+	end := bytes.IndexByte(line[1:min(len(line), maxNameLength+2)], '"') + 1
+	if end == 0 || end+1 >= len(line) || line[end+1] != ';' {
+		return nil, nil, false
+	}
+
+	name, value = line[1:end], line[end+2:]
+	if bytes.IndexByte(value, ';') >= 0 {
+		return nil, nil, false
+	}
+	if o.Trim {
+		value = trimSpace(value)
+	}
+	return name, value, true
+}
+
+// parseTemperature parses the temperature field into tenths of a degree
+func (o *Options) parseTemperature(value []byte) (int64, bool) {
+	if o.Scale > 0 {
+		return scaledIntParser(value, o.Scale)
+	}
+	if o.NoDecimal {
+		return integerStringToIntParser(value, o.Wide)
+	}
+	if o.Wide {
+		return wideStringToIntParser(value)
+	}
+	return customStringToIntParser(value)
+}
+
+// stationKey returns the key a station name is grouped by. Stations are
+// keyed by the name itself rather than a hash of it, so two names can never
+// collide into one station.
+func (o *Options) stationKey(name []byte) []byte {
+	if o.FoldCase {
+		return bytes.ToLower(name)
+	}
+	return name
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"flag"
+	"maps"
+	"strings"
+	"testing"
+)
+
+func TestIndexAssignmentIsReproducible(t *testing.T) {
+	fileName := writeFixture(t, fixture)
+	opts := Options{}
+
+	for _, engine := range engines {
+		t.Run(engine.name, func(t *testing.T) {
+			first, err := engine.evaluate(fileName, opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			second, err := engine.evaluate(fileName, opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !maps.Equal(first.stationSymbolMap, second.stationSymbolMap) {
+				t.Errorf("index assignment differs between runs:\n%v\n%v", first.stationSymbolMap, second.stationSymbolMap)
+			}
+			if got := string(appendResults(nil, first, &opts)); got != fixtureResult {
+				t.Errorf("got  %s\nwant %s", got, fixtureResult)
+			}
+		})
+	}
+}
+
+func TestHashSeedIsIgnored(t *testing.T) {
+	if flag.Lookup("hash-seed") == nil {
+		t.Fatal("-hash-seed is gone, old invocations fail")
+	}
+
+	fileName := writeFixture(t, fixture)
+	want, err := evaluateMmap(fileName, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := evaluateMmap(fileName, Options{HashSeed: 42})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !maps.Equal(got.stationSymbolMap, want.stationSymbolMap) {
+		t.Errorf("HashSeed changed the indexes:\n%v\n%v", got.stationSymbolMap, want.stationSymbolMap)
+	}
+}
+
+func TestDistinctNamesGetDistinctIndexes(t *testing.T) {
+	// names one bit apart, the ones a weak hash is most likely to collide
+	var content strings.Builder
+	for _, name := range []string{"Hamburg", "Hamburh", "Hamburf", "Hamburg\x00", "\x00Hamburg"} {
+		content.WriteString(name + ";1.0\n")
+	}
+	fileName := writeFixture(t, content.String())
+
+	for _, engine := range engines {
+		t.Run(engine.name, func(t *testing.T) {
+			agg, err := engine.evaluate(fileName, Options{})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			indexes := map[uint64]bool{}
+			for _, index := range agg.stationSymbolMap {
+				indexes[index] = true
+			}
+			if len(agg.stationSymbolMap) != 5 || len(indexes) != 5 {
+				t.Errorf("5 names got %d keys and %d indexes", len(agg.stationSymbolMap), len(indexes))
+			}
+			for name, s := range agg.stats(&Options{}) {
+				if s.count != 1 {
+					t.Errorf("%q aggregated %d readings, want 1", name, s.count)
+				}
+			}
+		})
+	}
+}
+
+func TestMaxNameLength(t *testing.T) {
+	garbage := strings.Repeat("x", 1<<20)
+	fileName := writeFixture(t, "Hamburg;12.0\n"+garbage+"\n"+garbage+";1.0\n"+strings.Repeat("y", 101)+";1.0\n"+strings.Repeat("z", 100)+";1.0\n")
+
+	for _, engine := range engines {
+		t.Run(engine.name, func(t *testing.T) {
+			agg, err := engine.evaluate(fileName, Options{})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			want := "{Hamburg=12.0/12.0/12.0, " + strings.Repeat("z", 100) + "=1.0/1.0/1.0}\n"
+			if got := string(appendResults(nil, agg, &Options{})); got != want {
+				t.Errorf("got  %.200s\nwant %.200s", got, want)
+			}
+			if agg.report.SkippedLines != 3 {
+				t.Errorf("skipped %d lines, want 3", agg.report.SkippedLines)
+			}
+			for _, m := range agg.report.Malformed {
+				if len(m.Content) > maxReportedLength {
+					t.Errorf("line %d reported with %d bytes", m.Line, len(m.Content))
+				}
+			}
+
+			if _, err := engine.evaluate(fileName, Options{Strict: true}); err == nil {
+				t.Error("strict run accepted an overlong line")
+			}
+			agg, err = engine.evaluate(fileName, Options{MaxNameLength: 1 << 21})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if agg.report.SkippedLines != 1 {
+				t.Errorf("with a raised limit skipped %d lines, want 1", agg.report.SkippedLines)
+			}
+		})
+	}
+}
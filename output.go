@@ -0,0 +1,289 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"slices"
+	"strconv"
+)
+
+// streamOutputThreshold is the number of stations above which the output is
+// streamed entry by entry instead of being built in one buffer
+const streamOutputThreshold = 10_000
+
+// stationResult pairs a station name with its totals
+type stationResult struct {
+	name     []byte
+	result   cityTemperatureInfo
+	lines    lineSpan     // only with Options.TrackLines
+	extremes extremeLines // only with Options.TrackExtremes
+}
+
+// entries returns the stations to print, in output order
+func (agg *aggregation) entries(opts *Options) []stationResult {
+	entries := make([]stationResult, 0, len(agg.stationNames))
+	for _, station := range agg.stationNames {
+		key := string(opts.stationKey(station))
+		result := agg.results[agg.stationSymbolMap[key]]
+		if result.count == 0 {
+			// only ever seen on malformed lines
+			continue
+		}
+		entries = append(entries, stationResult{name: station, result: result, lines: agg.lines[key], extremes: agg.extremes[key]})
+	}
+	return entries
+}
+
+// Output formats
+const (
+	formatText   = "text"
+	formatLines  = "lines"
+	formatRange  = "range"
+	formatJSON   = "json"
+	formatJSONL  = "jsonl"
+	formatCSV    = "csv"
+	formatBinary = "binary"
+)
+
+// writeResults prints the aggregation to w in opts.Format. Small text
+// results are formatted in a single buffer and written at once, large ones
+// are streamed.
+func writeResults(w io.Writer, agg *aggregation, opts *Options) error {
+	if opts.NoNewline && opts.Format != formatBinary {
+		w = &finalNewlineTrimmer{w: w}
+	}
+	if opts.Histogram {
+		return writeHistograms(w, agg, opts)
+	}
+
+	switch opts.Format {
+	case "", formatText:
+	case formatLines, formatRange:
+		return writeLines(w, agg.entries(opts), opts)
+	case formatJSON:
+		return writeJSON(w, printedEntries(agg.entries(opts), opts), opts)
+	case formatJSONL:
+		return writeJSONLines(w, printedEntries(agg.entries(opts), opts), opts)
+	case formatCSV:
+		return writeCSV(w, printedEntries(agg.entries(opts), opts), opts)
+	case formatBinary:
+		return writeBinary(w, agg.entries(opts))
+	default:
+		return fmt.Errorf("unknown format %q", opts.Format)
+	}
+
+	if len(agg.stationNames) > streamOutputThreshold {
+		return streamResults(w, agg, opts)
+	}
+
+	_, err := w.Write(appendResults(nil, agg, opts))
+	return err
+}
+
+// finalNewlineTrimmer passes writes through but holds back a trailing '\n'
+// until more output follows, so the newline ending the output is dropped
+type finalNewlineTrimmer struct {
+	w       io.Writer
+	pending bool
+}
+
+func (t *finalNewlineTrimmer) Write(p []byte) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+	if t.pending {
+		if _, err := t.w.Write([]byte{'\n'}); err != nil {
+			return 0, err
+		}
+		t.pending = false
+	}
+
+	body := p
+	if p[len(p)-1] == '\n' {
+		body = p[:len(p)-1]
+		t.pending = true
+	}
+	if _, err := t.w.Write(body); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// appendResults appends the aggregation formatted as
+// {station1=min/avg/max, station2=min/avg/max, ...}
+func appendResults(buf []byte, agg *aggregation, opts *Options) []byte {
+	buf = slices.Grow(buf, 50000)
+	buf = append(buf, '{')
+
+	entries := agg.entries(opts)
+	for i, entry := range printedEntries(entries, opts) {
+		if i != 0 {
+			buf = append(buf, ',', ' ')
+		}
+		buf = appendStation(buf, entry, opts)
+	}
+
+	buf = append(buf, '}', '\n')
+	if opts.Summary {
+		buf = appendSummary(buf, entries, opts)
+	}
+	return buf
+}
+
+// streamResults writes the same output as appendResults through a buffered
+// writer, so memory use doesn't grow with the number of stations beyond the
+// sorted entry list itself
+func streamResults(w io.Writer, agg *aggregation, opts *Options) error {
+	bw := bufio.NewWriterSize(w, 64*1024)
+	buf := make([]byte, 0, 256)
+
+	_ = bw.WriteByte('{')
+	entries := agg.entries(opts)
+	for i, entry := range printedEntries(entries, opts) {
+		buf = buf[:0]
+		if i != 0 {
+			buf = append(buf, ',', ' ')
+		}
+		buf = appendStation(buf, entry, opts)
+
+		if _, err := bw.Write(buf); err != nil {
+			return err
+		}
+	}
+	_, _ = bw.WriteString("}\n")
+	if opts.Summary {
+		_, _ = bw.Write(appendSummary(buf[:0], entries, opts))
+	}
+
+	return bw.Flush()
+}
+
+// writeLines writes one station=min/avg/max entry per line, without the
+// braces and separators of the text format, or one station=min..max entry
+// in the range format. entries are all stations, the
+// summary is taken over them.
+func writeLines(w io.Writer, entries []stationResult, opts *Options) error {
+	bw := bufio.NewWriterSize(w, 64*1024)
+	buf := make([]byte, 0, 256)
+
+	for _, entry := range printedEntries(entries, opts) {
+		buf = appendStation(buf[:0], entry, opts)
+		buf = append(buf, '\n')
+		if _, err := bw.Write(buf); err != nil {
+			return err
+		}
+	}
+	if opts.Summary {
+		_, _ = bw.Write(appendSummary(buf[:0], entries, opts))
+	}
+
+	return bw.Flush()
+}
+
+// appendSummary appends an ALL=min/avg/max line over every reading of every
+// station
+func appendSummary(buf []byte, entries []stationResult, opts *Options) []byte {
+	total := stationResult{name: []byte("ALL")}
+	for _, entry := range entries {
+		total.result.merge(entry.result)
+	}
+	if total.result.count == 0 {
+		return buf
+	}
+
+	buf = appendStation(buf, total, opts)
+	return append(buf, '\n')
+}
+
+// appendStation appends a single station=min/avg/max entry, or
+// station=min..max in the range format, with /count after it with
+// opts.WithCount
+func appendStation(buf []byte, entry stationResult, opts *Options) []byte {
+	result := entry.result
+
+	buf = append(buf, entry.name...)
+	buf = append(buf, '=')
+	if opts.Format == formatRange {
+		// no mean, so the sum is never divided
+		buf = appendTemperature(buf, result.min, opts)
+		buf = append(buf, '.', '.')
+		buf = appendTemperature(buf, result.max, opts)
+	} else {
+		buf = appendTemperature(buf, result.min, opts)
+		buf = append(buf, '/')
+		buf = appendMean(buf, result, opts)
+		buf = append(buf, '/')
+		buf = appendTemperature(buf, result.max, opts)
+	}
+	if opts.WithCount {
+		buf = append(buf, '/')
+		buf = strconv.AppendInt(buf, result.count, 10)
+	}
+
+	return buf
+}
+
+// appendTenths appends a temperature given in tenths of a degree, exactly.
+// It formats with integer arithmetic, which is several times faster than
+// going through a float and strconv.
+func appendTenths(buf []byte, tenths int64) []byte {
+	abs := uint64(tenths)
+	if tenths < 0 {
+		buf = append(buf, '-')
+		abs = -abs
+	}
+	buf = strconv.AppendUint(buf, abs/10, 10)
+	return append(buf, '.', byte('0'+abs%10))
+}
+
+// appendTemperature appends a temperature given in tenths of a degree,
+// rounded to the precision of opts
+func appendTemperature(buf []byte, tenths int64, opts *Options) []byte {
+	if opts.precision() == 1 {
+		return appendTenths(buf, tenths)
+	}
+	return appendRounded(buf, float64(tenths), 1, opts.precision())
+}
+
+// appendMean appends the average temperature of a station, rounded to the
+// precision of opts, or with as many digits as float64 holds in exact mode
+func appendMean(buf []byte, result cityTemperatureInfo, opts *Options) []byte {
+	if opts.Exact {
+		return strconv.AppendFloat(buf, result.sumFloat()/(float64(result.count)*10), 'f', -1, 64)
+	}
+	if opts.precision() == 1 && result.sumHigh == 0 {
+		return appendTenths(buf, roundedMean(result.sum, result.count))
+	}
+	return appendRounded(buf, result.sumFloat(), float64(result.count), opts.precision())
+}
+
+// roundedMean returns sum/count rounded to an integer, halves toward
+// positive infinity like appendRounded, without the float division
+func roundedMean(sum, count int64) int64 {
+	quotient, remainder := sum/count, sum%count
+	if remainder < 0 {
+		quotient--
+		remainder += count
+	}
+	// remainder >= count/2, without overflowing for huge counts
+	if remainder >= count-remainder {
+		quotient++
+	}
+	return quotient
+}
+
+// appendRounded appends tenths/count, given in tenths of a degree, rounded
+// to precision decimals. Halves round toward positive infinity like the
+// reference implementation does, not to even like strconv.
+func appendRounded(buf []byte, tenths, count float64, precision int) []byte {
+	// in units of the last printed decimal, scaled so that a half is exact
+	var scaled float64
+	if precision >= 1 {
+		scaled = tenths * math.Pow10(precision-1) / count
+	} else {
+		scaled = tenths / (count * math.Pow10(1-precision))
+	}
+	return strconv.AppendFloat(buf, math.Floor(scaled+0.5)/math.Pow10(precision), 'f', precision, 64)
+}
@@ -0,0 +1,370 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"math"
+	"math/rand/v2"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestStreamResults(t *testing.T) {
+	var fixture strings.Builder
+	for i := 0; i < numberOfMaxStations; i++ {
+		fmt.Fprintf(&fixture, "station-%05d;%d.%d\n", i, i%100, i%10)
+		fmt.Fprintf(&fixture, "station-%05d;-%d.%d\n", i, i%10, i%10)
+	}
+	fileName := writeFixture(t, fixture.String())
+
+	opts := Options{}
+	agg, err := evaluateMmap(fileName, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var streamed bytes.Buffer
+	if err := streamResults(&streamed, agg, &opts); err != nil {
+		t.Fatal(err)
+	}
+
+	if want := appendResults(nil, agg, &opts); !bytes.Equal(streamed.Bytes(), want) {
+		t.Fatalf("streamed output differs from buffered output")
+	}
+	if got := strings.Count(streamed.String(), "="); got != numberOfMaxStations {
+		t.Errorf("streamed %d stations, want %d", got, numberOfMaxStations)
+	}
+	if !strings.HasPrefix(streamed.String(), "{station-00000=0.0/0.0/0.0, station-00001=-1.1/0.0/1.1, ") {
+		t.Errorf("unexpected output start %.60s", streamed.String())
+	}
+	if !strings.HasSuffix(streamed.String(), ", station-09999=-9.9/45.0/99.9}\n") {
+		t.Errorf("unexpected output end %s", streamed.String()[streamed.Len()-60:])
+	}
+}
+
+func TestSummary(t *testing.T) {
+	fileName := writeFixture(t, "a;1.0\nb;-3.5\na;2.5\nc;10.0\n")
+	opts := Options{Summary: true}
+
+	for _, engine := range engines {
+		t.Run(engine.name, func(t *testing.T) {
+			agg, err := engine.evaluate(fileName, opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// (1.0 - 3.5 + 2.5 + 10.0) / 4 = 2.5
+			want := "{a=1.0/1.8/2.5, b=-3.5/-3.5/-3.5, c=10.0/10.0/10.0}\nALL=-3.5/2.5/10.0\n"
+			if got := string(appendResults(nil, agg, &opts)); got != want {
+				t.Errorf("got  %s\nwant %s", got, want)
+			}
+
+			var streamed bytes.Buffer
+			if err := streamResults(&streamed, agg, &opts); err != nil {
+				t.Fatal(err)
+			}
+			if streamed.String() != want {
+				t.Errorf("streamed %s\nwant     %s", streamed.String(), want)
+			}
+		})
+	}
+}
+
+func TestStructuredFormats(t *testing.T) {
+	fileName := writeFixture(t, "b;1.0\n\"a\";-3.5\nb;2.5\n")
+	opts := Options{TrackLines: true}
+
+	agg, err := evaluateMmap(fileName, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		format string
+		want   string
+	}{
+		{formatJSON, `[
+{"station":"\"a\"","min":-3.5,"mean":-3.5,"max":-3.5,"count":1,"first_line":2,"last_line":2},
+{"station":"b","min":1.0,"mean":1.8,"max":2.5,"count":2,"first_line":1,"last_line":3}
+]
+`},
+		{formatCSV, `station,min,mean,max,count,first_line,last_line
+"""a""",-3.5,-3.5,-3.5,1,2,2
+b,1.0,1.8,2.5,2,1,3
+`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.format, func(t *testing.T) {
+			opts := opts
+			opts.Format = tt.format
+
+			var out bytes.Buffer
+			if err := writeResults(&out, agg, &opts); err != nil {
+				t.Fatal(err)
+			}
+			if out.String() != tt.want {
+				t.Errorf("got\n%s\nwant\n%s", out.String(), tt.want)
+			}
+		})
+	}
+}
+
+func TestExactPartialsMerge(t *testing.T) {
+	lines := strings.SplitAfter(fixture, "\n")
+	opts := Options{Exact: true, Format: formatCSV}
+
+	// exactCSV aggregates content and returns its csv rows by station
+	exactCSV := func(content string) map[string][]string {
+		agg, err := evaluateMmap(writeFixture(t, content), opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var out bytes.Buffer
+		if err := writeResults(&out, agg, &opts); err != nil {
+			t.Fatal(err)
+		}
+		records, err := csv.NewReader(&out).ReadAll()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		rows := map[string][]string{}
+		for _, record := range records[1:] {
+			rows[record[0]] = record
+		}
+		return rows
+	}
+
+	// merge the partials by their exact sums, in tenths, and counts
+	sums, counts := map[string]int64{}, map[string]int64{}
+	for _, part := range []string{strings.Join(lines[:5], ""), strings.Join(lines[5:], "")} {
+		for name, record := range exactCSV(part) {
+			count, _ := strconv.ParseInt(record[4], 10, 64)
+			sum, _ := strconv.ParseFloat(record[5], 64)
+			counts[name] += count
+			sums[name] += int64(math.Round(sum * 10))
+		}
+	}
+
+	whole := exactCSV(fixture)
+	if len(whole) != len(sums) {
+		t.Fatalf("partials have %d stations, the whole file %d", len(sums), len(whole))
+	}
+	for name, record := range whole {
+		mean := strconv.FormatFloat(float64(sums[name])/(float64(counts[name])*10), 'f', -1, 64)
+		if mean != record[2] {
+			t.Errorf("%s: merged mean %s, whole file mean %s", name, mean, record[2])
+		}
+	}
+	if got := whole["Hamburg"][2]; got != "13.633333333333333" {
+		t.Errorf("Hamburg mean %s, want it unrounded", got)
+	}
+}
+
+func TestLinesFormat(t *testing.T) {
+	agg, err := evaluateMmap(writeFixture(t, fixture), Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	opts := Options{Format: formatLines}
+	var out bytes.Buffer
+	if err := writeResults(&out, agg, &opts); err != nil {
+		t.Fatal(err)
+	}
+
+	lines := strings.Split(strings.TrimSuffix(out.String(), "\n"), "\n")
+	if len(lines) != 9 {
+		t.Fatalf("got %d lines for 9 stations:\n%s", len(lines), out.String())
+	}
+	// the text format without braces, one entry per line
+	want := strings.Split(strings.Trim(fixtureResult, "{}\n"), ", ")
+	if !slices.Equal(lines, want) {
+		t.Errorf("got  %q\nwant %q", lines, want)
+	}
+}
+
+func TestRangeFormat(t *testing.T) {
+	agg, err := evaluateMmap(writeFixture(t, fixture), Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, summary := range []bool{false, true} {
+		var full, ranges bytes.Buffer
+		if err := writeResults(&full, agg, &Options{Format: formatLines, Summary: summary}); err != nil {
+			t.Fatal(err)
+		}
+		if err := writeResults(&ranges, agg, &Options{Format: formatRange, Summary: summary}); err != nil {
+			t.Fatal(err)
+		}
+
+		// Hamburg=12.0/15.1/18.2 becomes Hamburg=12.0..18.2
+		var want []string
+		for _, line := range strings.Split(strings.TrimSuffix(full.String(), "\n"), "\n") {
+			name, values, _ := strings.Cut(line, "=")
+			fields := strings.Split(values, "/")
+			want = append(want, name+"="+fields[0]+".."+fields[2])
+		}
+		if got := strings.Split(strings.TrimSuffix(ranges.String(), "\n"), "\n"); !slices.Equal(got, want) {
+			t.Errorf("summary %t: got  %q\nwant %q", summary, got, want)
+		}
+	}
+
+	var counted bytes.Buffer
+	if err := writeResults(&counted, agg, &Options{Format: formatRange, WithCount: true}); err != nil {
+		t.Fatal(err)
+	}
+	if want := "Hamburg=-5.3..34.2/3\n"; !strings.Contains(counted.String(), want) {
+		t.Errorf("got %q, want it to contain %q", counted.String(), want)
+	}
+}
+
+func TestCountIsInteger(t *testing.T) {
+	// one more than float64 holds exactly
+	const count = 1<<53 + 1
+	agg := &aggregation{
+		stationNames:     [][]byte{[]byte("Big")},
+		stationSymbolMap: map[string]uint64{"Big": 0},
+		results:          cityMap{{count: count, min: -10, max: 10}},
+	}
+
+	for _, format := range []string{formatText, formatLines, formatJSON, formatJSONL, formatCSV} {
+		var out bytes.Buffer
+		if err := writeResults(&out, agg, &Options{Format: format, WithCount: true}); err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(out.String(), "9007199254740993") || strings.Contains(out.String(), "e+") {
+			t.Errorf("%s: count %d not printed as a plain integer:\n%s", format, count, out.String())
+		}
+	}
+
+	var out bytes.Buffer
+	if err := writeResults(&out, agg, &Options{WithCount: true}); err != nil {
+		t.Fatal(err)
+	}
+	if want := "{Big=-1.0/0.0/1.0/9007199254740993}\n"; out.String() != want {
+		t.Errorf("got %q, want %q", out.String(), want)
+	}
+}
+
+func TestJSONLinesFormat(t *testing.T) {
+	agg, err := evaluateMmap(writeFixture(t, fixture), Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var jsonOut, jsonlOut bytes.Buffer
+	if err := writeResults(&jsonOut, agg, &Options{Format: formatJSON, Exact: true}); err != nil {
+		t.Fatal(err)
+	}
+	if err := writeResults(&jsonlOut, agg, &Options{Format: formatJSONL, Exact: true}); err != nil {
+		t.Fatal(err)
+	}
+
+	var want []map[string]any
+	if err := json.Unmarshal(jsonOut.Bytes(), &want); err != nil {
+		t.Fatal(err)
+	}
+	var got []map[string]any
+	for i, line := range strings.Split(strings.TrimSuffix(jsonlOut.String(), "\n"), "\n") {
+		var station map[string]any
+		if err := json.Unmarshal([]byte(line), &station); err != nil {
+			t.Fatalf("line %d %q: %v", i+1, line, err)
+		}
+		got = append(got, station)
+	}
+	if !slices.EqualFunc(got, want, maps.Equal) {
+		t.Errorf("got  %v\nwant %v", got, want)
+	}
+}
+
+func TestNoNewline(t *testing.T) {
+	agg, err := evaluateMmap(writeFixture(t, fixture), Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, format := range []string{formatText, formatLines, formatRange, formatJSON, formatJSONL, formatCSV} {
+		for _, summary := range []bool{false, true} {
+			withNewline, withoutNewline := Options{Format: format, Summary: summary}, Options{Format: format, Summary: summary, NoNewline: true}
+
+			var want, got bytes.Buffer
+			if err := writeResults(&want, agg, &withNewline); err != nil {
+				t.Fatal(err)
+			}
+			if err := writeResults(&got, agg, &withoutNewline); err != nil {
+				t.Fatal(err)
+			}
+
+			if !bytes.HasSuffix(want.Bytes(), []byte("\n")) {
+				t.Errorf("%s, summary %t: output doesn't end with a newline by default", format, summary)
+			}
+			if got.String() != strings.TrimSuffix(want.String(), "\n") {
+				t.Errorf("%s, summary %t: got %q\nwant %q without its final newline", format, summary, got.String(), want.String())
+			}
+		}
+	}
+}
+
+func TestAppendTenths(t *testing.T) {
+	for tenths := int64(-99999); tenths <= 99999; tenths++ {
+		if got, want := string(appendTenths(nil, tenths)), strconv.FormatFloat(float64(tenths)/10, 'f', 1, 64); got != want {
+			t.Fatalf("appendTenths(%d) = %s, want %s", tenths, got, want)
+		}
+	}
+	if got := string(appendTenths(nil, math.MinInt64)); got != "-922337203685477580.8" {
+		t.Errorf("appendTenths(MinInt64) = %s", got)
+	}
+	if got := string(appendTenths(nil, math.MaxInt64)); got != "922337203685477580.7" {
+		t.Errorf("appendTenths(MaxInt64) = %s", got)
+	}
+
+	// means agree with the float formatter they replace
+	rng := rand.New(rand.NewPCG(1, 2))
+	for range 100_000 {
+		count := rng.Int64N(1000) + 1
+		sum := (rng.Int64N(1999) - 999) * count / (rng.Int64N(count) + 1)
+		if got, want := string(appendTenths(nil, roundedMean(sum, count))), string(appendRounded(nil, float64(sum), float64(count), 1)); got != want {
+			t.Fatalf("mean of %d/%d = %s, want %s", sum, count, got, want)
+		}
+	}
+	for _, tt := range []struct{ sum, count, want int64 }{
+		{5, 2, 3}, {-5, 2, -2}, {-3, 2, -1}, {7, 7, 1}, {-1, 3, 0}, {-2, 3, -1},
+		{math.MaxInt64, math.MaxInt64, 1}, {math.MinInt64, math.MaxInt64, -1},
+	} {
+		if got := roundedMean(tt.sum, tt.count); got != tt.want {
+			t.Errorf("roundedMean(%d, %d) = %d, want %d", tt.sum, tt.count, got, tt.want)
+		}
+	}
+}
+
+func TestPrecision(t *testing.T) {
+	// means of 0.25, -0.25, 12.55 and -12.55 are halves at one decimal
+	fileName := writeFixture(t, "a;0.2\na;0.3\nb;-0.2\nb;-0.3\nc;12.5\nc;12.6\nd;-12.5\nd;-12.6\n")
+	agg, err := evaluateMmap(fileName, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		precision int
+		want      string
+	}{
+		// -0.3 rounds to 0, not -0, and -12.5 to -12
+		{-1, "{a=0/0/0, b=0/0/0, c=13/13/13, d=-13/-13/-12}\n"},
+		{0, "{a=0.2/0.3/0.3, b=-0.3/-0.2/-0.2, c=12.5/12.6/12.6, d=-12.6/-12.5/-12.5}\n"},
+		{2, "{a=0.20/0.25/0.30, b=-0.30/-0.25/-0.20, c=12.50/12.55/12.60, d=-12.60/-12.55/-12.50}\n"},
+	}
+	for _, tt := range tests {
+		opts := Options{Precision: tt.precision}
+		if got := string(appendResults(nil, agg, &opts)); got != tt.want {
+			t.Errorf("precision %d: got  %s\nwant %s", tt.precision, got, tt.want)
+		}
+	}
+}
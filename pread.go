@@ -0,0 +1,176 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"slices"
+	"sync"
+	"sync/atomic"
+)
+
+// preadBufferSize is how much of its range each pread worker reads at once
+const preadBufferSize = 4 << 20
+
+// evaluatePread splits the file into one byte range per worker, like
+// evaluateMmap, but every worker reads its range with ReadAt into a buffer
+// of its own instead of mapping the file. That keeps the address space
+// small for multi-GB files while still reading in parallel.
+func evaluatePread(fileName string, opts Options) (*aggregation, error) {
+	workers := opts.Workers
+	if workers == 0 {
+		workers = workerCount
+	}
+
+	f, err := os.Open(fileName)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	stat, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	size := stat.Size()
+	if opts.Sample > 0 {
+		if size, err = sampleSize(f, size, &opts); err != nil {
+			return nil, err
+		}
+	}
+	if size, err = sizeWithoutIncomplete(f, size, &opts); err != nil {
+		return nil, err
+	}
+
+	discovery := make([]byte, min(size, discoveryWindow))
+	if _, err := f.ReadAt(discovery, 0); err != nil && !errors.Is(err, io.EOF) {
+		return nil, err
+	}
+	stationNames, stationSymbolMap := discoverStations(discovery, &opts)
+
+	var (
+		workerResults = make(WorkerResults, workers)
+		workerReports = make([][]chunkReport, workers)
+		workerErrors  = make([]error, workers)
+		// set by a worker that gave up, so the others stop reading
+		stopped atomic.Bool
+	)
+	wg := sync.WaitGroup{}
+	for workerID := 0; workerID < workers; workerID++ {
+		start, end := size*int64(workerID)/int64(workers), size*int64(workerID+1)/int64(workers)
+
+		wg.Add(1)
+		go func(workerID int) {
+			defer wg.Done()
+			workerErrors[workerID] = preadRange(f, start, end, size, preadBufferSize, opts.MaxLineLength, func(data []byte) error {
+				if stopped.Load() {
+					return errStopped
+				}
+				// only the chunk at offset 0 gets seq 0, the rest are
+				// numbered once all workers are done. With fewer bytes than
+				// workers, that isn't always worker 0's.
+				seq := 1
+				if start == 0 && len(workerReports[workerID]) == 0 {
+					seq = 0
+				}
+				report := aggregateChunk(data, seq, stationSymbolMap, &workerResults[workerID], &opts)
+				workerReports[workerID] = append(workerReports[workerID], report)
+				if err := workerResults[workerID].err; err != nil {
+					stopped.Store(true)
+					return err
+				}
+				return nil
+			})
+		}(workerID)
+	}
+	wg.Wait()
+
+	for _, err := range workerErrors {
+		if err != nil && !errors.Is(err, errStopped) {
+			return nil, err
+		}
+	}
+
+	chunkReports := slices.Concat(workerReports...)
+	for i := range chunkReports {
+		chunkReports[i].seq = i
+	}
+	return finishAggregation(workerResults, chunkReports, stationNames, stationSymbolMap, &opts)
+}
+
+// errStopped ends the read of a pread worker after another one gave up
+var errStopped = errors.New("stopped by another worker")
+
+// preadRange reads the lines of f that start within [start, end) and hands
+// them to fn in chunks of whole lines, reading bufSize bytes at a time. A
+// line crossing end is read to its finish, the one crossing start belongs
+// to the range before. Lines longer than bufSize grow the buffer, up to
+// maxLineLength unless that is zero. An error of fn stops the read and is
+// returned.
+func preadRange(f *os.File, start, end, size int64, bufSize, maxLineLength int, fn func([]byte) error) error {
+	pos := start
+	if start > 0 {
+		var err error
+		if pos, err = nextLineStart(f, start-1, size); err != nil {
+			return err
+		}
+	}
+
+	buf := make([]byte, bufSize)
+	filled := 0
+	for pos < end {
+		n, err := f.ReadAt(buf[filled:], pos+int64(filled))
+		if err != nil && !errors.Is(err, io.EOF) {
+			return err
+		}
+		filled += n
+		data := buf[:filled]
+		eof := pos+int64(filled) >= size
+
+		// the lines starting before end are over once a '\n' at or after
+		// end-1 is found
+		if past := end - 1 - pos; past < int64(filled) {
+			if i := bytes.IndexByte(data[past:], '\n'); i >= 0 {
+				return fn(data[:past+int64(i)+1])
+			}
+		}
+		if eof {
+			return fn(data)
+		}
+
+		cut := bytes.LastIndexByte(data, '\n') + 1
+		if cut == 0 {
+			// a line longer than the buffer, grow it until the line fits
+			if maxLineLength > 0 && filled >= maxLineLength {
+				return &LineTooLongError{Offset: pos, MaxLineLength: maxLineLength}
+			}
+			buf = append(buf, make([]byte, len(buf))...)
+			continue
+		}
+		if err := fn(data[:cut]); err != nil {
+			return err
+		}
+		filled = copy(buf, data[cut:])
+		pos += int64(cut)
+	}
+
+	return nil
+}
+
+// nextLineStart returns the offset right after the first '\n' at or after
+// offset, or size if there is none
+func nextLineStart(f *os.File, offset, size int64) (int64, error) {
+	buf := make([]byte, 4096)
+	for offset < size {
+		n, err := f.ReadAt(buf, offset)
+		if err != nil && !errors.Is(err, io.EOF) {
+			return 0, err
+		}
+		if i := bytes.IndexByte(buf[:n], '\n'); i >= 0 {
+			return offset + int64(i) + 1, nil
+		}
+		offset += int64(n)
+	}
+	return size, nil
+}
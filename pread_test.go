@@ -0,0 +1,87 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestEvaluatePread(t *testing.T) {
+	var fixture strings.Builder
+	for i := 0; i < 5000; i++ {
+		fmt.Fprintf(&fixture, "%s;%d.%d\n", strings.Repeat("x", i%23+1), i%100-50, i%10)
+	}
+	fileName := writeFixture(t, fixture.String())
+
+	want, err := evaluateMmap(fileName, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, workers := range []int{1, 3, 7, 64} {
+		got, err := evaluatePread(fileName, Options{Workers: workers})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if g, w := string(appendResults(nil, got, &Options{})), string(appendResults(nil, want, &Options{})); g != w {
+			t.Errorf("%d workers: got  %.200s\nwant %.200s", workers, g, w)
+		}
+		if !reflect.DeepEqual(got.report, want.report) {
+			t.Errorf("%d workers: report %+v, want %+v", workers, got.report, want.report)
+		}
+	}
+}
+
+func TestPreadRange(t *testing.T) {
+	content := "a;1.0\nbb;2.0\nccc;3.0\n" + strings.Repeat("d", 40) + ";4.0\ne;5.0"
+	fileName := writeFixture(t, content)
+	f, err := os.Open(fileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	size := int64(len(content))
+
+	// adjacent ranges read with tiny buffers must cover every line once
+	for _, bufSize := range []int{1, 4, 16, 1024} {
+		for parts := 1; parts <= len(content); parts++ {
+			var got strings.Builder
+			for i := 0; i < parts; i++ {
+				start, end := size*int64(i)/int64(parts), size*int64(i+1)/int64(parts)
+				err := preadRange(f, start, end, size, bufSize, 0, func(data []byte) error {
+					if len(data) > 0 && data[len(data)-1] != '\n' && !strings.HasSuffix(content, string(data)) {
+						t.Errorf("chunk %q ends inside a line", data)
+					}
+					got.Write(data)
+					return nil
+				})
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+			if got.String() != content {
+				t.Fatalf("buffer %d, %d parts: got %q", bufSize, parts, got.String())
+			}
+		}
+	}
+}
+
+func TestPreadTinyFile(t *testing.T) {
+	// fewer bytes than workers, so the first ranges are empty
+	for _, tt := range []struct {
+		content string
+		opts    Options
+	}{
+		{"\xef\xbb\xbfA;1.0\n", Options{}},
+		{"station;temperature\nA;1.0\n", Options{SkipHeader: true, Workers: 40}},
+	} {
+		agg, err := evaluatePread(writeFixture(t, tt.content), tt.opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, want := string(appendResults(nil, agg, &tt.opts)), "{A=1.0/1.0/1.0}\n"; got != want {
+			t.Errorf("%q: got %q, want %q", tt.content, got, want)
+		}
+	}
+}
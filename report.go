@@ -0,0 +1,290 @@
+package main
+
+import (
+	"bytes"
+	"slices"
+	"strconv"
+	"unicode/utf8"
+)
+
+const (
+	// maxReportedLines caps how many malformed lines a Report keeps
+	maxReportedLines = 10
+
+	// maxReportedLength caps how much of each malformed line is kept, so
+	// corrupt input without newlines doesn't end up in memory twice
+	maxReportedLength = 256
+)
+
+// utf8BOM is the byte order mark some tools write at the start of a UTF-8
+// file. It isn't part of the first station name.
+var utf8BOM = []byte("\xef\xbb\xbf")
+
+// Report describes the data quality of an aggregated file. Malformed lines
+// are skipped rather than aborting the run, so this is the only place they
+// show up.
+type Report struct {
+	// TotalLines counts every line, including a header ignored with
+	// Options.SkipHeader, which is neither parsed nor skipped
+	TotalLines   int64
+	ParsedLines  int64
+	SkippedLines int64
+
+	// Malformed holds the first maxReportedLines skipped lines in file order
+	Malformed []MalformedLine
+
+	// InvalidNames holds the station names that aren't valid UTF-8, in
+	// output order. Only filled with Options.ValidateUTF8.
+	InvalidNames []string
+}
+
+type MalformedLine struct {
+	Line    int64  // 1-based line number in the file
+	Content string // truncated to maxReportedLength bytes
+	File    string // set only when aggregating several files
+}
+
+func (m MalformedLine) err() error {
+	return &MalformedLineError{m}
+}
+
+// chunkReport is the part of a Report collected by one worker for one chunk.
+// Workers don't know where their chunk starts in the file, so line numbers
+// are relative to the chunk until buildReport stitches the chunks together.
+type chunkReport struct {
+	seq       int // position of the chunk in the file
+	lines     int64
+	skipped   int64
+	header    bool // the chunk started with a header line that was ignored
+	malformed []MalformedLine
+
+	// dropped is the number of lines of a chunk left out with
+	// Options.BestEffort. They aren't counted, but still number the lines
+	// of the chunks after it.
+	dropped int64
+
+	// seen holds the chunk relative line span of every station, keyed by
+	// station key. Only filled with Options.TrackLines.
+	seen map[string]*lineSpan
+
+	// extremes holds the chunk relative lines of the lowest and highest
+	// reading of every station, keyed by station key. Only filled with
+	// Options.TrackExtremes.
+	extremes map[string]*extremeLines
+}
+
+// lineSpan is the first and last line a station was seen on
+type lineSpan struct {
+	first int64
+	last  int64
+}
+
+func (r *chunkReport) skip(line []byte) {
+	r.skipped++
+	if len(r.malformed) < maxReportedLines {
+		r.malformed = append(r.malformed, MalformedLine{Line: r.lines, Content: string(line[:min(len(line), maxReportedLength)])})
+	}
+}
+
+// skipHeader cuts off what precedes the data, if the chunk is the first
+// one of the file: a UTF-8 byte order mark, and the header line with
+// Options.SkipHeader. The header counts as a line, so line numbers still
+// match the file, but not as a parsed one.
+func (r *chunkReport) skipHeader(chunk []byte, opts *Options) []byte {
+	if r.seq != 0 {
+		return chunk
+	}
+	chunk = bytes.TrimPrefix(chunk, utf8BOM)
+	if !opts.SkipHeader || len(chunk) == 0 {
+		return chunk
+	}
+
+	_, chunk = cutLine(chunk)
+	r.lines++
+	r.header = true
+	return chunk
+}
+
+// see records that the station was seen on the current line
+func (r *chunkReport) see(key []byte) {
+	if r.seen == nil {
+		r.seen = make(map[string]*lineSpan)
+	}
+
+	// update in place, assigning a string(key) index would allocate
+	if span, ok := r.seen[string(key)]; ok {
+		span.last = r.lines
+		return
+	}
+	r.seen[string(key)] = &lineSpan{first: r.lines, last: r.lines}
+}
+
+// extremeLines is the lowest and highest reading of a station and the line
+// each of them was first read on
+type extremeLines struct {
+	min     int64
+	max     int64
+	minLine int64
+	maxLine int64
+}
+
+// seeExtremes records the reading on the current line if it is a new
+// extreme of the station. Ties keep the earlier line.
+func (r *chunkReport) seeExtremes(key []byte, temperature int64) {
+	if r.extremes == nil {
+		r.extremes = make(map[string]*extremeLines)
+	}
+
+	e, ok := r.extremes[string(key)]
+	if !ok {
+		r.extremes[string(key)] = &extremeLines{min: temperature, max: temperature, minLine: r.lines, maxLine: r.lines}
+		return
+	}
+	if temperature < e.min {
+		e.min, e.minLine = temperature, r.lines
+	}
+	if temperature > e.max {
+		e.max, e.maxLine = temperature, r.lines
+	}
+}
+
+// buildReport combines the reports of all chunks of a file, turning chunk
+// relative line numbers into absolute ones
+func buildReport(chunks []chunkReport) Report {
+	slices.SortFunc(chunks, func(a, b chunkReport) int {
+		return a.seq - b.seq
+	})
+
+	var (
+		report Report
+		offset int64
+	)
+	for _, c := range chunks {
+		for _, m := range c.malformed {
+			if len(report.Malformed) == maxReportedLines {
+				break
+			}
+			m.Line += offset
+			report.Malformed = append(report.Malformed, m)
+		}
+
+		offset += c.lines + c.dropped
+		report.TotalLines += c.lines
+		report.SkippedLines += c.skipped
+		if c.header {
+			report.ParsedLines--
+		}
+	}
+	report.ParsedLines += report.TotalLines - report.SkippedLines
+
+	return report
+}
+
+// countLines counts the lines of chunk, including a last one without '\n'
+func countLines(chunk []byte) int64 {
+	lines := int64(bytes.Count(chunk, []byte{'\n'}))
+	if len(chunk) > 0 && chunk[len(chunk)-1] != '\n' {
+		lines++
+	}
+	return lines
+}
+
+// buildLineSpans combines the line spans of all chunks of a file, turning
+// chunk relative line numbers into absolute ones
+func buildLineSpans(chunks []chunkReport) map[string]lineSpan {
+	slices.SortFunc(chunks, func(a, b chunkReport) int {
+		return a.seq - b.seq
+	})
+
+	spans := make(map[string]lineSpan)
+	var offset int64
+	for _, c := range chunks {
+		for key, seen := range c.seen {
+			span := *seen
+			span.first += offset
+			span.last += offset
+			if earlier, ok := spans[key]; ok {
+				span.first = earlier.first
+			}
+			spans[key] = span
+		}
+		offset += c.lines + c.dropped
+	}
+
+	return spans
+}
+
+// buildExtremeLines combines the extremes of all chunks of a file, turning
+// chunk relative line numbers into absolute ones
+func buildExtremeLines(chunks []chunkReport) map[string]extremeLines {
+	slices.SortFunc(chunks, func(a, b chunkReport) int {
+		return a.seq - b.seq
+	})
+
+	extremes := make(map[string]extremeLines)
+	var offset int64
+	for _, c := range chunks {
+		for key, seen := range c.extremes {
+			e := *seen
+			e.minLine += offset
+			e.maxLine += offset
+			if earlier, ok := extremes[key]; ok {
+				// chunks come in file order, so on a tie the earlier wins
+				if earlier.min <= e.min {
+					e.min, e.minLine = earlier.min, earlier.minLine
+				}
+				if earlier.max >= e.max {
+					e.max, e.maxLine = earlier.max, earlier.maxLine
+				}
+			}
+			extremes[key] = e
+		}
+		offset += c.lines + c.dropped
+	}
+
+	return extremes
+}
+
+// appendReport appends a human readable summary of skipped lines, and of
+// invalid station names if there are any
+func appendReport(buf []byte, r Report) []byte {
+	if r.SkippedLines > 0 || len(r.InvalidNames) == 0 {
+		buf = append(buf, "skipped "...)
+		buf = strconv.AppendInt(buf, r.SkippedLines, 10)
+		buf = append(buf, " of "...)
+		buf = strconv.AppendInt(buf, r.TotalLines, 10)
+		buf = append(buf, " lines\n"...)
+
+		for _, m := range r.Malformed {
+			buf = append(buf, "  "...)
+			if m.File != "" {
+				buf = append(buf, m.File...)
+				buf = append(buf, ": "...)
+			}
+			buf = append(buf, "line "...)
+			buf = strconv.AppendInt(buf, m.Line, 10)
+			buf = append(buf, ": "...)
+			buf = strconv.AppendQuote(buf, m.Content)
+			buf = append(buf, '\n')
+		}
+	}
+
+	for _, name := range r.InvalidNames {
+		buf = append(buf, "station name is not valid UTF-8: "...)
+		buf = strconv.AppendQuote(buf, name)
+		buf = append(buf, '\n')
+	}
+
+	return buf
+}
+
+// invalidNames returns the names that aren't valid UTF-8
+func invalidNames(names [][]byte) []string {
+	var invalid []string
+	for _, name := range names {
+		if !utf8.Valid(name) {
+			invalid = append(invalid, string(name))
+		}
+	}
+	return invalid
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"bytes"
+	"io"
+)
+
+// sampleReader passes on the lines of r up to the lines-th that parses and
+// then reports EOF, for Options.Sample. Malformed lines are passed on but
+// not counted.
+type sampleReader struct {
+	r     io.Reader
+	lines int64
+	opts  *Options
+	// line holds the start of the line the last Read ended in
+	line []byte
+}
+
+func (s *sampleReader) Read(p []byte) (int, error) {
+	if s.lines <= 0 {
+		return 0, io.EOF
+	}
+
+	n, err := s.r.Read(p)
+	for off := 0; off < n; {
+		i := bytes.IndexByte(p[off:n], '\n')
+		if i < 0 {
+			s.line = append(s.line, p[off:n]...)
+			break
+		}
+		line := p[off : off+i]
+		if len(s.line) > 0 {
+			s.line = append(s.line, line...)
+			line = s.line
+		}
+		off += i + 1
+		if s.parses(line) {
+			s.lines--
+		}
+		s.line = s.line[:0]
+		if s.lines == 0 {
+			return off, io.EOF
+		}
+	}
+	return n, err
+}
+
+// parses reports whether line would be aggregated rather than skipped
+func (s *sampleReader) parses(line []byte) bool {
+	_, value, ok := s.opts.splitLine(line)
+	if !ok {
+		return false
+	}
+	_, ok = s.opts.parseTemperature(value)
+	return ok
+}
+
+// sampleSize returns the length of the sample of opts.Sample lines of the
+// size bytes of r, so the file engines can treat a sample as a shorter file
+func sampleSize(r io.ReaderAt, size int64, opts *Options) (int64, error) {
+	return io.Copy(io.Discard, &sampleReader{r: io.NewSectionReader(r, 0, size), lines: opts.Sample, opts: opts})
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"cmp"
+	"fmt"
+	"slices"
+)
+
+// Keys the output can be sorted by with Options.Sort, besides the metrics
+// of -top-by
+const (
+	sortByName = "name"
+	sortByAvg  = "avg"
+)
+
+// Orders of Options.Order
+const (
+	orderAsc  = "asc"
+	orderDesc = "desc"
+)
+
+// sortEntries puts entries, in name order, into the order of opts.Sort and
+// opts.Order. The sort is stable, so stations with the same value stay in
+// name order either way.
+func sortEntries(entries []stationResult, opts *Options) []stationResult {
+	desc := opts.Order == orderDesc
+	if opts.Sort == "" || opts.Sort == sortByName {
+		if desc {
+			entries = slices.Clone(entries)
+			slices.Reverse(entries)
+		}
+		return entries
+	}
+
+	value := metricOf(opts.Sort)
+	sorted := slices.Clone(entries)
+	slices.SortStableFunc(sorted, func(a, b stationResult) int {
+		if desc {
+			return cmp.Compare(value(b.result), value(a.result))
+		}
+		return cmp.Compare(value(a.result), value(b.result))
+	})
+	return sorted
+}
+
+// validateSort checks the key passed with -sort and the order of -order
+func validateSort(key, order string) error {
+	switch key {
+	case "", sortByName, topByMin, topByMax, sortByAvg, topByMean, topByCount:
+	default:
+		return fmt.Errorf("unknown -sort %q, want name, min, max, avg or count", key)
+	}
+	switch order {
+	case "", orderAsc, orderDesc:
+		return nil
+	}
+	return fmt.Errorf("unknown -order %q, want asc or desc", order)
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"bytes"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestSort(t *testing.T) {
+	fileName := writeFixture(t, "Accra;30.0\nOslo;30.0\nLima;12.0\nLima;29.9\nDakar;10.0\nDakar;30.0\nCairo;10.0\n")
+	agg, err := evaluateMmap(fileName, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		key       string
+		asc, desc string
+	}{
+		{sortByName, "Accra Cairo Dakar Lima Oslo", "Oslo Lima Dakar Cairo Accra"},
+		{topByMin, "Cairo Dakar Lima Accra Oslo", "Accra Oslo Lima Cairo Dakar"},
+		{topByMax, "Cairo Lima Accra Dakar Oslo", "Accra Dakar Oslo Lima Cairo"},
+		{sortByAvg, "Cairo Dakar Lima Accra Oslo", "Accra Oslo Lima Dakar Cairo"},
+		{topByCount, "Accra Cairo Oslo Dakar Lima", "Dakar Lima Accra Cairo Oslo"},
+	}
+	for _, tt := range tests {
+		for order, want := range map[string]string{orderAsc: tt.asc, orderDesc: tt.desc} {
+			opts := Options{Format: formatLines, Sort: tt.key, Order: order}
+			var out bytes.Buffer
+			if err := writeResults(&out, agg, &opts); err != nil {
+				t.Fatal(err)
+			}
+			var names []string
+			for _, line := range strings.Split(strings.TrimSuffix(out.String(), "\n"), "\n") {
+				name, _, _ := strings.Cut(line, "=")
+				names = append(names, name)
+			}
+			if !slices.Equal(names, strings.Fields(want)) {
+				t.Errorf("sort by %s %s: got %q, want %q", tt.key, order, names, want)
+			}
+		}
+	}
+
+	// -top picks the stations, -sort and -order still decide their order
+	for _, tt := range []struct {
+		sort, order string
+		want        string
+	}{
+		{"", "", "Dakar Lima Accra"},
+		{sortByName, "", "Accra Dakar Lima"},
+		{"", orderDesc, "Lima Dakar Accra"},
+		{topByMax, orderAsc, "Lima Accra Dakar"},
+	} {
+		opts := Options{Format: formatLines, Top: 3, TopBy: topByCount, Sort: tt.sort, Order: tt.order}
+		var names []string
+		for _, entry := range printedEntries(agg.entries(&opts), &opts) {
+			names = append(names, string(entry.name))
+		}
+		if !slices.Equal(names, strings.Fields(tt.want)) {
+			t.Errorf("top 3 by count, sort %q, order %q: got %q, want %q", tt.sort, tt.order, names, tt.want)
+		}
+	}
+
+	if err := validateSort("median", orderAsc); err == nil {
+		t.Error("validateSort accepted median")
+	}
+	if err := validateSort(sortByName, "up"); err == nil {
+		t.Error("validateSort accepted order up")
+	}
+}
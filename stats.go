@@ -0,0 +1,273 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"runtime"
+	"sync"
+)
+
+// defaultChunkSize is the read size of the streaming engine
+const defaultChunkSize = 16 * 1024 * 1024
+
+// Stats holds the totals of a single station, in tenths of a degree
+type Stats struct {
+	count int64
+	min   int64
+	max   int64
+	sum   int64
+
+	// sumHigh is the high word of sum, see addSum
+	sumHigh int64
+
+	// FirstLine and LastLine are the 1-based lines of the input the station
+	// was first and last seen on. They are only set with Options.TrackLines.
+	FirstLine int64
+	LastLine  int64
+}
+
+// Min returns the lowest reading in degrees
+func (s Stats) Min() float64 {
+	return float64(s.min) / 10
+}
+
+// Max returns the highest reading in degrees
+func (s Stats) Max() float64 {
+	return float64(s.max) / 10
+}
+
+// Avg returns the unrounded average of all readings in degrees, or zero
+// without readings
+func (s Stats) Avg() float64 {
+	if s.count == 0 {
+		return 0
+	}
+	info := s.info()
+	return info.sumFloat() / (float64(s.count) * 10)
+}
+
+// Count returns the number of readings
+func (s Stats) Count() int64 {
+	return s.count
+}
+
+// String renders s as min/avg/max, rounded to one decimal like the output
+func (s Stats) String() string {
+	if s.count == 0 {
+		return "-"
+	}
+
+	var opts Options
+	buf := appendTemperature(nil, s.min, &opts)
+	buf = append(buf, '/')
+	buf = appendMean(buf, s.info(), &opts)
+	buf = append(buf, '/')
+	buf = appendTemperature(buf, s.max, &opts)
+	return string(buf)
+}
+
+// info returns the totals of s without the line span
+func (s Stats) info() cityTemperatureInfo {
+	return cityTemperatureInfo{count: s.count, min: s.min, max: s.max, sum: s.sum, sumHigh: s.sumHigh}
+}
+
+// merge folds other into s. Line numbers of different inputs can't be
+// compared, so the merged span runs from the first line of s to the last
+// line of other.
+func (s *Stats) merge(other Stats) {
+	if other.count == 0 {
+		return
+	}
+	if s.count == 0 {
+		*s = other
+		return
+	}
+
+	info := s.info()
+	info.merge(other.info())
+	*s = newStats(info, lineSpan{first: s.FirstLine, last: other.LastLine})
+}
+
+// MergeStats folds the totals of src into dst, e.g. to combine the partial
+// results of inputs aggregated on different machines
+func MergeStats(dst, src map[string]Stats) {
+	for name, s := range src {
+		total := dst[name]
+		total.merge(s)
+		dst[name] = total
+	}
+}
+
+func newStats(info cityTemperatureInfo, lines lineSpan) Stats {
+	return Stats{
+		count:     info.count,
+		min:       info.min,
+		max:       info.max,
+		sum:       info.sum,
+		sumHigh:   info.sumHigh,
+		FirstLine: lines.first,
+		LastLine:  lines.last,
+	}
+}
+
+// AggregateReader aggregates the measurements read from r with the
+// streaming engine, so any source works: network streams, decompressors,
+// in-memory readers
+func AggregateReader(r io.Reader, opts Options) (map[string]Stats, error) {
+	chunkSize := opts.ChunkSize
+	if chunkSize == 0 {
+		chunkSize = defaultChunkSize
+	}
+
+	agg, err := evaluateReader(r, workerCount, chunkSize, opts)
+	if err != nil {
+		return nil, err
+	}
+	return agg.stats(&opts), nil
+}
+
+// AggregateBytes aggregates the measurements in data, which the caller
+// already holds in memory, with the parallel engine behind the mmap one.
+// With Options.BestEffort the stats of the healthy workers come along with
+// the error of a failed one.
+func AggregateBytes(data []byte, opts Options) (map[string]Stats, error) {
+	workers := opts.Workers
+	if workers == 0 {
+		workers = workerCount
+	}
+	if opts.Sample > 0 {
+		workers = 1
+		size, err := sampleSize(bytes.NewReader(data), int64(len(data)), &opts)
+		if err != nil {
+			return nil, err
+		}
+		data = data[:size]
+	}
+
+	agg, err := aggregateData(dropIncomplete(data, &opts), workers, opts)
+	if agg == nil {
+		return nil, err
+	}
+	return agg.stats(&opts), err
+}
+
+// AggregateRange aggregates the lines of the file at path that start within
+// the byte range [start, end), so nodes of a cluster can each take a range
+// of the same file and merge their totals with MergeStats. A line cut by
+// start belongs to the range before; a line cut by end is read past end to
+// its newline. Lines are numbered from the start of the range, and
+// Options.SkipHeader only skips a line in the range starting at 0.
+func AggregateRange(path string, start, end int64, opts Options) (map[string]Stats, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	data, err := readRange(f, start, end)
+	if err != nil {
+		return nil, err
+	}
+	if start > 0 {
+		opts.SkipHeader = false
+	}
+	return AggregateBytes(data, opts)
+}
+
+// rangeReadSize is how much readRange reads at a time past the end of a
+// range to finish its last line
+const rangeReadSize = 4096
+
+// readRange reads the lines of r that start within [start, end)
+func readRange(r io.ReaderAt, start, end int64) ([]byte, error) {
+	if start >= end {
+		return nil, nil
+	}
+	// one byte before start tells whether a line starts at start
+	from := max(start-1, 0)
+	data := make([]byte, end-from)
+	n, err := r.ReadAt(data, from)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return nil, err
+	}
+	data = data[:n]
+
+	// finish the line cut by end, unless the file ended first
+	if int64(n) == end-from && data[n-1] != '\n' {
+		more := make([]byte, rangeReadSize)
+		for off := end; ; off += rangeReadSize {
+			n, err := r.ReadAt(more, off)
+			if err != nil && !errors.Is(err, io.EOF) {
+				return nil, err
+			}
+			if i := bytes.IndexByte(more[:n], '\n'); i >= 0 {
+				data = append(data, more[:i+1]...)
+				break
+			}
+			data = append(data, more[:n]...)
+			if n < rangeReadSize {
+				break
+			}
+		}
+	}
+
+	if start > 0 {
+		// drop the end of the line that started in the range before
+		i := bytes.IndexByte(data, '\n')
+		if i < 0 {
+			return nil, nil
+		}
+		data = data[i+1:]
+	}
+	return data, nil
+}
+
+// AggregateReaders aggregates several readers in parallel, e.g. the
+// partitions of a sharded input, and merges their totals by station name.
+// The workers are shared out between the readers. Lines are numbered per
+// reader, so with Options.TrackLines the span runs from the first line in
+// the first reader the station is in to its last line in the last one.
+func AggregateReaders(readers []io.Reader, opts Options) (map[string]Stats, error) {
+	workers := opts.Workers
+	if workers == 0 {
+		workers = min(max(runtime.NumCPU()-1, 1), workerCount)
+	}
+	opts.Workers = max(workers/max(len(readers), 1), 1)
+
+	var (
+		partials = make([]map[string]Stats, len(readers))
+		errs     = make([]error, len(readers))
+		wg       sync.WaitGroup
+	)
+	for i, r := range readers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			partials[i], errs[i] = AggregateReader(r, opts)
+		}()
+	}
+	wg.Wait()
+	if err := errors.Join(errs...); err != nil {
+		return nil, err
+	}
+
+	// merge in reader order, so the result doesn't depend on scheduling
+	stats := make(map[string]Stats)
+	for _, partial := range partials {
+		MergeStats(stats, partial)
+	}
+	return stats, nil
+}
+
+// stats returns the totals of every station keyed by name
+func (agg *aggregation) stats(opts *Options) map[string]Stats {
+	entries := agg.entries(opts)
+
+	stats := make(map[string]Stats, len(entries))
+	for _, entry := range entries {
+		stats[string(entry.name)] = newStats(entry.result, entry.lines)
+	}
+	return stats
+}
@@ -0,0 +1,240 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"maps"
+	"math"
+	"strings"
+	"testing"
+	"testing/iotest"
+)
+
+func TestAggregateReader(t *testing.T) {
+	got, err := AggregateReader(strings.NewReader(fixture), Options{ChunkSize: 128})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string]Stats{
+		"Bridgetown": {count: 1, min: 269, max: 269, sum: 269},
+		"Bulawayo":   {count: 1, min: 89, max: 89, sum: 89},
+		"Conakry":    {count: 1, min: 312, max: 312, sum: 312},
+		"Cracow":     {count: 1, min: 126, max: 126, sum: 126},
+		"Hamburg":    {count: 3, min: -53, max: 342, sum: 409},
+		"Istanbul":   {count: 2, min: 62, max: 230, sum: 292},
+		"Palembang":  {count: 1, min: 388, max: 388, sum: 388},
+		"Roseau":     {count: 1, min: 344, max: 344, sum: 344},
+		"St. John's": {count: 1, min: 152, max: 152, sum: 152},
+	}
+	if !maps.Equal(got, want) {
+		t.Errorf("got  %v\nwant %v", got, want)
+	}
+}
+
+func TestLastLineWithoutNewline(t *testing.T) {
+	content := strings.TrimSuffix(fixture, "\n")
+	want := map[string]Stats{"Hamburg": {count: 3, min: -53, max: 342, sum: 409}}
+
+	readers := map[string]io.Reader{
+		// hands out the last bytes together with io.EOF
+		"data and EOF": iotest.DataErrReader(strings.NewReader(content)),
+		"one byte":     iotest.OneByteReader(strings.NewReader(content)),
+	}
+	for name, r := range readers {
+		got, err := AggregateReader(r, Options{ChunkSize: 128})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got["Hamburg"] != want["Hamburg"] || len(got) != 9 {
+			t.Errorf("%s: got %v", name, got)
+		}
+	}
+
+	fileName := writeFixture(t, content)
+	for _, engine := range engines {
+		agg, err := engine.evaluate(fileName, Options{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := string(appendResults(nil, agg, &Options{})); got != fixtureResult {
+			t.Errorf("%s: got  %s\nwant %s", engine.name, got, fixtureResult)
+		}
+	}
+}
+
+func TestMergeStats(t *testing.T) {
+	lines := strings.SplitAfter(fixture, "\n")
+
+	merged := map[string]Stats{}
+	for _, part := range []string{strings.Join(lines[:7], ""), strings.Join(lines[7:], "")} {
+		partial, err := AggregateReader(strings.NewReader(part), Options{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		MergeStats(merged, partial)
+	}
+
+	want, err := AggregateReader(strings.NewReader(fixture), Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !maps.Equal(merged, want) {
+		t.Errorf("got  %v\nwant %v", merged, want)
+	}
+}
+
+func TestAggregateReaders(t *testing.T) {
+	lines := strings.SplitAfter(fixture, "\n")
+	readers := []io.Reader{
+		strings.NewReader(strings.Join(lines[:4], "")),
+		strings.NewReader(strings.Join(lines[4:9], "")),
+		strings.NewReader(strings.Join(lines[9:], "")),
+	}
+
+	got, err := AggregateReaders(readers, Options{ChunkSize: 128})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, err := AggregateReader(strings.NewReader(fixture), Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !maps.Equal(got, want) {
+		t.Errorf("got  %v\nwant %v", got, want)
+	}
+
+	readers = []io.Reader{strings.NewReader(fixture), iotest.ErrReader(errors.New("partition lost"))}
+	if _, err := AggregateReaders(readers, Options{}); err == nil || !strings.Contains(err.Error(), "partition lost") {
+		t.Errorf("err = %v, want the error of the failing reader", err)
+	}
+}
+
+func TestStatsAccessors(t *testing.T) {
+	stats, err := AggregateReader(strings.NewReader(fixture+"Vostok;-89.2\nVostok;-0.1\n"), Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name          string
+		min, avg, max float64
+		count         int64
+		str           string
+	}{
+		{"Hamburg", -5.3, 40.9 / 3, 34.2, 3, "-5.3/13.6/34.2"},
+		{"Istanbul", 6.2, 14.6, 23.0, 2, "6.2/14.6/23.0"},
+		// -44.65 rounds toward positive infinity
+		{"Vostok", -89.2, -44.65, -0.1, 2, "-89.2/-44.6/-0.1"},
+	}
+	for _, tt := range tests {
+		s := stats[tt.name]
+		if s.Min() != tt.min || s.Max() != tt.max || s.Count() != tt.count {
+			t.Errorf("%s: min %v, max %v, count %d; want %v, %v, %d", tt.name, s.Min(), s.Max(), s.Count(), tt.min, tt.max, tt.count)
+		}
+		if math.Abs(s.Avg()-tt.avg) > 1e-9 {
+			t.Errorf("%s: avg %v, want %v", tt.name, s.Avg(), tt.avg)
+		}
+		if s.String() != tt.str {
+			t.Errorf("%s: String() = %q, want %q", tt.name, s.String(), tt.str)
+		}
+	}
+
+	var empty Stats
+	if empty.Avg() != 0 || empty.Count() != 0 || empty.String() != "-" {
+		t.Errorf("zero Stats: avg %v, count %d, %q", empty.Avg(), empty.Count(), empty.String())
+	}
+}
+
+func TestAggregateBytes(t *testing.T) {
+	want, err := AggregateReader(strings.NewReader(fixture), Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, opts := range []Options{{}, {Workers: 3, Parts: 7}} {
+		got, err := AggregateBytes([]byte(fixture), opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !maps.Equal(got, want) {
+			t.Errorf("%+v: got  %v\nwant %v", opts, got, want)
+		}
+	}
+
+	got, err := AggregateBytes([]byte(fixture), Options{Sample: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := map[string]Stats{"Hamburg": {count: 1, min: 120, max: 120, sum: 120}}; !maps.Equal(got, want) {
+		t.Errorf("sample: got %v, want %v", got, want)
+	}
+
+	// a worker panics on part 1, the other takes the parts left and its
+	// stats still come back
+	aggregatePart = func(chunk []byte, seq int, symbols map[string]uint64, result *workerResult, opts *Options) chunkReport {
+		if seq == 1 {
+			panic("injected")
+		}
+		return aggregateChunk(chunk, seq, symbols, result, opts)
+	}
+	t.Cleanup(func() { aggregatePart = aggregateChunk })
+	got, err = AggregateBytes([]byte(strings.Repeat(fixture, 10)), Options{Workers: 2, Parts: 8, BestEffort: true})
+	if !errors.Is(err, ErrWorkerPanicked) || len(got) == 0 {
+		t.Errorf("best effort: got %v, %v; want partial stats and the panic", got, err)
+	}
+}
+
+func TestAggregateRange(t *testing.T) {
+	// long lines, so the read past the end of a range takes several reads
+	content := fixture + "Stuttgart-" + strings.Repeat("x", 2*rangeReadSize) + ";1.5\n" + fixture
+	fileName := writeFixture(t, content)
+	want, err := AggregateBytes([]byte(content), Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	lineStart := int64(strings.Index(content, "Stuttgart"))
+	size := int64(len(content))
+	for _, cuts := range [][2]int64{
+		{size / 3, 2 * size / 3},       // mid-line
+		{lineStart, lineStart + 10},    // at a line start, and inside the long line
+		{lineStart - 1, lineStart + 1}, // around a newline
+		{0, size},                      // empty ranges at either end
+		{5, 5},                         // an empty range in between
+	} {
+		got := make(map[string]Stats)
+		for _, r := range [][2]int64{{0, cuts[0]}, {cuts[0], cuts[1]}, {cuts[1], size}} {
+			partial, err := AggregateRange(fileName, r[0], r[1], Options{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			MergeStats(got, partial)
+		}
+		if !maps.Equal(got, want) {
+			t.Errorf("cut at %v: got  %v\nwant %v", cuts, got, want)
+		}
+	}
+}
+
+func FuzzAggregateBytes(f *testing.F) {
+	f.Add([]byte(fixture))
+	f.Add([]byte(""))
+	f.Add([]byte("a;1.0"))
+	f.Add([]byte("\xef\xbb\xbfa;-99.9\n;\n\n;;1.0\nb;+1.0\n"))
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		got, err := AggregateBytes(data, Options{Workers: 3})
+		if err != nil {
+			t.Fatal(err)
+		}
+		// the streaming engine reads the same lines
+		want, err := AggregateReader(bytes.NewReader(data), Options{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !maps.Equal(got, want) {
+			t.Errorf("got  %v\nwant %v", got, want)
+		}
+	})
+}
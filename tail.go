@@ -0,0 +1,82 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+)
+
+// tailWindow is how much tailStart reads at a time, backwards from the end
+const tailWindow = 64 * 1024
+
+// evaluateTail aggregates the last opts.Tail lines of fileName, for any
+// engine: it seeks back from the end to where they start and reads just
+// those lines, so the rest of the file is never read. Line numbers in the
+// report count from the first of them.
+func evaluateTail(fileName string, opts Options) (*aggregation, error) {
+	f, err := os.Open(fileName)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	stat, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if !stat.Mode().IsRegular() {
+		return nil, fmt.Errorf("%s: -tail needs a regular file to seek in", fileName)
+	}
+
+	start, err := tailStart(f, stat.Size(), opts.Tail)
+	if err != nil {
+		return nil, err
+	}
+	data, err := readRange(f, start, stat.Size())
+	if err != nil {
+		return nil, err
+	}
+	if start > 0 {
+		// the header is long gone
+		opts.SkipHeader = false
+	}
+
+	workers := opts.Workers
+	if workers == 0 {
+		workers = workerCount
+	}
+	return aggregateData(dropIncomplete(data, &opts), workers, opts)
+}
+
+// tailStart returns the offset of the first of the last lines lines of the
+// size bytes of r, or 0 if there are no more lines than that. A last line
+// without a newline counts as a line.
+func tailStart(r io.ReaderAt, size, lines int64) (int64, error) {
+	window := make([]byte, tailWindow)
+	seen := int64(0)
+	for end := size; end > 0; {
+		off := max(end-tailWindow, 0)
+		n := int(end - off)
+		if _, err := r.ReadAt(window[:n], off); err != nil {
+			return 0, err
+		}
+		data := window[:n]
+		if end == size && data[n-1] == '\n' {
+			// the newline ending the last line doesn't start another one
+			data = data[:n-1]
+		}
+		for {
+			i := bytes.LastIndexByte(data, '\n')
+			if i < 0 {
+				break
+			}
+			if seen++; seen == lines {
+				return off + int64(i) + 1, nil
+			}
+			data = data[:i]
+		}
+		end = off
+	}
+	return 0, nil
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"bytes"
+	"cmp"
+	"fmt"
+	"slices"
+)
+
+// Metrics stations can be ranked by with Options.Top
+const (
+	topByMin   = "min"
+	topByMean  = "mean"
+	topByMax   = "max"
+	topByCount = "count"
+)
+
+// printedEntries narrows entries, all stations in output order, down to the
+// ones to print, in the order of Options.Sort. Totals like the summary line
+// are still taken over all of them.
+func printedEntries(entries []stationResult, opts *Options) []stationResult {
+	if len(opts.Exclude) > 0 {
+		excluded := make(map[string]bool, len(opts.Exclude))
+		for _, name := range opts.Exclude {
+			excluded[string(opts.stationKey([]byte(name)))] = true
+		}
+		entries = slices.DeleteFunc(slices.Clone(entries), func(entry stationResult) bool {
+			return excluded[string(opts.stationKey(entry.name))]
+		})
+	}
+	sorted := opts.Sort != "" || opts.Order != ""
+	if opts.Top > 0 {
+		top := topEntries(entries, opts.Top, opts.TopBy)
+		if !sorted {
+			return top
+		}
+		// sortEntries wants the stations in name order, not ranked
+		kept := make(map[string]bool, len(top))
+		for _, entry := range top {
+			kept[string(entry.name)] = true
+		}
+		entries = slices.DeleteFunc(slices.Clone(entries), func(entry stationResult) bool {
+			return !kept[string(entry.name)]
+		})
+	}
+	if sorted {
+		entries = sortEntries(entries, opts)
+	}
+	return entries
+}
+
+// topEntries returns the n entries with the highest value of the metric,
+// highest first. Metrics tie often, max and min in particular, so ties are
+// broken by name to make the order total and the output the same on every
+// run.
+func topEntries(entries []stationResult, n int, metric string) []stationResult {
+	value := metricOf(metric)
+	ranked := slices.Clone(entries)
+	slices.SortFunc(ranked, func(a, b stationResult) int {
+		if c := cmp.Compare(value(b.result), value(a.result)); c != 0 {
+			return c
+		}
+		return bytes.Compare(a.name, b.name)
+	})
+	return ranked[:min(n, len(ranked))]
+}
+
+// metricOf returns the function computing the named metric of a station.
+// Unknown names fall back to max, validateTopBy rejects them up front.
+func metricOf(metric string) func(cityTemperatureInfo) float64 {
+	switch metric {
+	case topByMin:
+		return func(r cityTemperatureInfo) float64 { return float64(r.min) }
+	case topByMean, sortByAvg:
+		return func(r cityTemperatureInfo) float64 { return r.sumFloat() / float64(r.count) }
+	case topByCount:
+		return func(r cityTemperatureInfo) float64 { return float64(r.count) }
+	default:
+		return func(r cityTemperatureInfo) float64 { return float64(r.max) }
+	}
+}
+
+// validateTopBy checks the metric passed with -top-by
+func validateTopBy(metric string) error {
+	switch metric {
+	case "", topByMin, topByMean, topByMax, topByCount:
+		return nil
+	}
+	return fmt.Errorf("unknown -top-by %q, want min, mean, max or count", metric)
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"bytes"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestTopTies(t *testing.T) {
+	// four stations share the highest max, listed out of name order
+	fileName := writeFixture(t, "Oslo;30.0\nAccra;30.0\nLima;12.0\nDakar;30.0\nCairo;30.0\nLima;29.9\n")
+	agg, err := evaluateMmap(fileName, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		opts Options
+		want string
+	}{
+		{Options{Top: 3}, "{Accra=30.0/30.0/30.0, Cairo=30.0/30.0/30.0, Dakar=30.0/30.0/30.0}\n"},
+		{Options{Top: 5, TopBy: topByMax}, "{Accra=30.0/30.0/30.0, Cairo=30.0/30.0/30.0, Dakar=30.0/30.0/30.0, Oslo=30.0/30.0/30.0, Lima=12.0/21.0/29.9}\n"},
+		{Options{Top: 2, TopBy: topByCount}, "{Lima=12.0/21.0/29.9, Accra=30.0/30.0/30.0}\n"},
+		{Options{Top: 1, TopBy: topByMin, Summary: true}, "{Accra=30.0/30.0/30.0}\nALL=12.0/27.0/30.0\n"},
+	}
+	for _, tt := range tests {
+		if got := string(appendResults(nil, agg, &tt.opts)); got != tt.want {
+			t.Errorf("top %d by %q: got  %s\nwant %s", tt.opts.Top, tt.opts.TopBy, got, tt.want)
+		}
+
+		// the ranking must not depend on the order the entries come in
+		entries := agg.entries(&tt.opts)
+		ranked := topEntries(entries, tt.opts.Top, tt.opts.TopBy)
+		slices.Reverse(entries)
+		reversed := topEntries(entries, tt.opts.Top, tt.opts.TopBy)
+		if !slices.EqualFunc(ranked, reversed, func(a, b stationResult) bool { return bytes.Equal(a.name, b.name) }) {
+			t.Errorf("top %d by %q of reversed entries differs", tt.opts.Top, tt.opts.TopBy)
+		}
+	}
+
+	if err := validateTopBy("median"); err == nil {
+		t.Error("validateTopBy accepted median")
+	}
+}
+
+func TestExclude(t *testing.T) {
+	fileName := writeFixture(t, fixture)
+	agg, err := evaluateMmap(fileName, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	listFile := writeFixture(t, "Hamburg\nSt. John's\n")
+	for _, list := range []string{"Hamburg,St. John's", "@" + listFile} {
+		names, err := parseStationList(list)
+		if err != nil {
+			t.Fatal(err)
+		}
+		opts := Options{Exclude: names, Summary: true}
+		got := string(appendResults(nil, agg, &opts))
+		if strings.Contains(got, "Hamburg") || strings.Contains(got, "St. John's") {
+			t.Errorf("excluding %s: got %s", list, got)
+		}
+		// the lowest reading, Hamburg's -5.3, is still in the summary
+		if !strings.HasSuffix(got, "ALL=-5.3/19.8/38.8\n") {
+			t.Errorf("excluding %s: summary of %s", list, got)
+		}
+	}
+}
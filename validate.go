@@ -0,0 +1,134 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"syscall"
+)
+
+// validateFile checks that every line of the file has a delimiter and a
+// parsable temperature, without aggregating anything. All malformed lines
+// are counted in the returned report, regardless of opts.Strict.
+func validateFile(fileName string, opts Options) (Report, error) {
+	f, err := os.Open(fileName)
+	if err != nil {
+		return Report{}, err
+	}
+	defer f.Close()
+
+	stat, err := f.Stat()
+	if err != nil {
+		return Report{}, err
+	}
+	if !stat.Mode().IsRegular() {
+		// pipes can't be mapped, as in selectEngine
+		return validateReader(f, defaultChunkSize, opts)
+	}
+	gz, err := isGzipFile(fileName)
+	if err != nil {
+		return Report{}, err
+	}
+	if gz {
+		zr, err := gzip.NewReader(f)
+		if err != nil {
+			return Report{}, err
+		}
+		return validateReader(truncationReader{zr}, defaultChunkSize, opts)
+	}
+	if stat.Size() == 0 {
+		return Report{}, nil
+	}
+
+	data, err := syscall.Mmap(int(f.Fd()), 0, int(stat.Size()), syscall.PROT_READ, syscall.MAP_SHARED)
+	if errors.Is(err, syscall.ENOMEM) {
+		// not enough address space for the whole file, as in evaluateMmap,
+		// but validating needs no windows, reading it through will do
+		opts.logger().Warn("file too large to map at once, reading it through", "file", fileName, "size", stat.Size())
+		return validateReader(f, defaultChunkSize, opts)
+	}
+	if err != nil {
+		return Report{}, fmt.Errorf("%w: %s: %w", ErrMmap, fileName, err)
+	}
+	defer syscall.Munmap(data)
+
+	workers := opts.Workers
+	if workers == 0 {
+		workers = workerCount
+	}
+	reports := make([]chunkReport, workers)
+
+	wg := sync.WaitGroup{}
+	for workerID, part := range splitAtNewlines(data, workers) {
+		wg.Add(1)
+		go func(workerID int, part []byte) {
+			defer wg.Done()
+			reports[workerID] = validateChunk(part, workerID, &opts)
+		}(workerID, part)
+	}
+	wg.Wait()
+
+	return buildReport(reports), nil
+}
+
+// validateReader is validateFile for input that can't be mapped. It reads
+// chunkSize bytes at a time and validates them up to the last newline,
+// growing the buffer for lines longer than that.
+func validateReader(r io.Reader, chunkSize int, opts Options) (Report, error) {
+	var (
+		reports []chunkReport
+		buf     = make([]byte, chunkSize)
+		filled  int
+	)
+	for seq := 0; ; {
+		n, err := io.ReadFull(r, buf[filled:])
+		filled += n
+		eof := errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF)
+		if err != nil && !eof {
+			return Report{}, err
+		}
+
+		end := filled
+		if !eof {
+			end = bytes.LastIndexByte(buf[:filled], '\n') + 1
+			if end == 0 {
+				buf = append(buf, make([]byte, len(buf))...)
+				continue
+			}
+		}
+		reports = append(reports, validateChunk(buf[:end], seq, &opts))
+		seq++
+
+		filled = copy(buf, buf[end:filled])
+		if eof {
+			return buildReport(reports), nil
+		}
+	}
+}
+
+// validateChunk is aggregateChunk without the aggregation
+func validateChunk(chunk []byte, seq int, opts *Options) chunkReport {
+	report := chunkReport{seq: seq}
+	chunk = report.skipHeader(chunk, opts)
+
+	var line []byte
+	for len(chunk) > 0 {
+		line, chunk = cutLine(chunk)
+		report.lines++
+
+		_, value, ok := opts.splitLine(line)
+		if !ok {
+			report.skip(line)
+			continue
+		}
+		if _, ok := opts.parseTemperature(value); !ok {
+			report.skip(line)
+		}
+	}
+
+	return report
+}
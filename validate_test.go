@@ -0,0 +1,73 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"syscall"
+	"testing"
+)
+
+func TestValidateFile(t *testing.T) {
+	t.Run("clean", func(t *testing.T) {
+		report, err := validateFile(writeFixture(t, fixture), Options{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if report.TotalLines != 12 || report.SkippedLines != 0 || len(report.Malformed) != 0 {
+			t.Errorf("report = %+v, want 12 clean lines", report)
+		}
+	})
+
+	t.Run("corrupted", func(t *testing.T) {
+		report, err := validateFile(writeFixture(t, "Hamburg;12.0\nHamburg;1x.0\nHamburg\nHamburg;1.0\n"), Options{Strict: true})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if report.TotalLines != 4 || report.SkippedLines != 2 {
+			t.Errorf("report = %+v, want 2 of 4 lines malformed", report)
+		}
+		want := []MalformedLine{{Line: 2, Content: "Hamburg;1x.0"}, {Line: 3, Content: "Hamburg"}}
+		if !slices.Equal(report.Malformed, want) {
+			t.Errorf("malformed = %+v, want %+v", report.Malformed, want)
+		}
+	})
+}
+
+func TestValidatePipe(t *testing.T) {
+	content := "Hamburg;12.0\nHamburg;1x.0\nHamburg\n" + strings.Repeat("x", 40) + ";1.0\nHamburg;1.0"
+	want := []MalformedLine{{Line: 2, Content: "Hamburg;1x.0"}, {Line: 3, Content: "Hamburg"}}
+
+	// chunks that cut lines in half, and one line longer than a chunk
+	for _, chunkSize := range []int{8, 16, 1024} {
+		report, err := validateReader(strings.NewReader(content), chunkSize, Options{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if report.TotalLines != 5 || !slices.Equal(report.Malformed, want) {
+			t.Errorf("%d byte chunks: report = %+v, want lines 2 and 3 of 5 malformed", chunkSize, report)
+		}
+	}
+
+	fileName := filepath.Join(t.TempDir(), "measurements.fifo")
+	if err := syscall.Mkfifo(fileName, 0o600); err != nil {
+		t.Skip("named pipes not supported: ", err)
+	}
+	go func() {
+		f, err := os.OpenFile(fileName, os.O_WRONLY, 0)
+		if err != nil {
+			return
+		}
+		defer f.Close()
+		_, _ = f.WriteString(content)
+	}()
+
+	report, err := validateFile(fileName, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.TotalLines != 5 || !slices.Equal(report.Malformed, want) {
+		t.Errorf("validateFile on a pipe: report = %+v, want lines 2 and 3 of 5 malformed", report)
+	}
+}
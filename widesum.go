@@ -0,0 +1,53 @@
+package main
+
+import "math/big"
+
+// Sums of readings are 128 bits wide: sumHigh*2^64 + sum, with sum taken
+// as signed. Merges always carry into sumHigh, which costs nothing next to
+// the per line work. Adding single readings only does with
+// Options.WideSum, as an int64 sum holds the readings of a billion rows
+// millions of times over.
+
+// addSum adds v to the 128 bit sum high*2^64 + low and returns the new
+// words
+func addSum(high, low, v int64) (int64, int64) {
+	sum := low + v
+	switch {
+	case v > 0 && sum < low:
+		high++
+	case v < 0 && sum > low:
+		high--
+	}
+	return high, sum
+}
+
+// addWide is add for Options.WideSum, carrying overflows of sum into
+// sumHigh
+func (c *cityTemperatureInfo) addWide(temperature int64) {
+	sumHigh, sum := addSum(c.sumHigh, c.sum, temperature)
+	c.add(temperature)
+	c.sumHigh, c.sum = sumHigh, sum
+}
+
+// sumFloat returns the sum of all readings, in tenths of a degree
+func (c *cityTemperatureInfo) sumFloat() float64 {
+	return float64(c.sumHigh)*0x1p64 + float64(c.sum)
+}
+
+// appendSum appends the exact sum of all readings in degrees, like
+// appendTenths does for a sum that fits 64 bits
+func appendSum(buf []byte, c cityTemperatureInfo) []byte {
+	if c.sumHigh == 0 {
+		return appendTenths(buf, c.sum)
+	}
+
+	n := new(big.Int).Lsh(big.NewInt(c.sumHigh), 64)
+	n.Add(n, big.NewInt(c.sum))
+	if n.Sign() < 0 {
+		buf = append(buf, '-')
+		n.Neg(n)
+	}
+	digits := n.String()
+	buf = append(buf, digits[:len(digits)-1]...)
+	return append(buf, '.', digits[len(digits)-1])
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"io"
+	"math"
+	"testing"
+)
+
+func TestAddSum(t *testing.T) {
+	high, low := addSum(0, math.MaxInt64, 1)
+	if high != 1 || low != math.MinInt64 {
+		t.Errorf("MaxInt64 + 1 = %d, %d; want 1, MinInt64", high, low)
+	}
+	if high, low = addSum(high, low, -1); high != 0 || low != math.MaxInt64 {
+		t.Errorf("back down: %d, %d; want 0, MaxInt64", high, low)
+	}
+	if high, low = addSum(0, math.MinInt64, -1); high != -1 || low != math.MaxInt64 {
+		t.Errorf("MinInt64 - 1 = %d, %d; want -1, MaxInt64", high, low)
+	}
+}
+
+func TestWideSum(t *testing.T) {
+	// 2^53 readings of 99.9 each still fit an int64 sum, twice that don't
+	half := cityTemperatureInfo{count: 1 << 53, min: 999, max: 999, sum: 999 << 53}
+	total := half
+	total.merge(half)
+	if total.sumHigh == 0 {
+		t.Fatalf("sum %d didn't carry", total.sum)
+	}
+
+	// 999 << 54 tenths
+	if got, want := string(appendSum(nil, total)), "1799638411097250201.6"; got != want {
+		t.Errorf("sum %s, want %s", got, want)
+	}
+	for _, opts := range []Options{{}, {Exact: true}} {
+		if got := string(appendMean(nil, total, &opts)); got != "99.9" {
+			t.Errorf("exact %t: mean %s, want 99.9", opts.Exact, got)
+		}
+	}
+	if got := newStats(total, lineSpan{}).Avg(); got != 99.9 {
+		t.Errorf("Avg() = %v, want 99.9", got)
+	}
+
+	// aggregating lines carries too, but only with WideSum
+	symbols := map[string]uint64{"a": 0}
+	for _, wide := range []bool{false, true} {
+		result := workerResult{cities: cityMap{{count: 1, min: 999, max: 999, sum: math.MaxInt64 - 999}}}
+		aggregateChunk([]byte("a;99.9\na;99.9\nb;-99.9\n"), 0, symbols, &result, &Options{WideSum: wide})
+		if got := result.cities[0].sumHigh; got != map[bool]int64{false: 0, true: 1}[wide] {
+			t.Errorf("wide %t: sumHigh = %d", wide, got)
+		}
+		if got := result.missed["b"].info; got.sum != -999 || got.sumHigh != 0 {
+			t.Errorf("wide %t: missed station sum %d, %d", wide, got.sumHigh, got.sum)
+		}
+	}
+
+	if err := writeBinary(io.Discard, []stationResult{{name: []byte("a"), result: total}}); err == nil {
+		t.Error("binary format took a sum beyond 64 bits")
+	}
+}